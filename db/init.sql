@@ -1,8 +1,50 @@
 CREATE TABLE IF NOT EXISTS "deductions" (
     id SERIAL PRIMARY KEY,
     "name" TEXT UNIQUE,
-    maxAmount REAL
+    maxAmount NUMERIC(14, 2)
 );
 INSERT INTO "deductions" ("name", maxAmount)
 VALUES ('personal', 60000.0),
-('k-receipt', 50000.0);
\ No newline at end of file
+('k-receipt', 50000.0);
+CREATE TABLE IF NOT EXISTS "tax_brackets" (
+    id SERIAL PRIMARY KEY,
+    tax_year INTEGER NOT NULL,
+    lower_bound NUMERIC(14, 2) NOT NULL,
+    upper_bound NUMERIC(14, 2),
+    rate NUMERIC(7, 6) NOT NULL,
+    UNIQUE (tax_year, lower_bound)
+);
+INSERT INTO "tax_brackets" (tax_year, lower_bound, upper_bound, rate)
+SELECT y.tax_year, b.lower_bound, b.upper_bound, b.rate
+FROM (VALUES (2566), (2567), (2568), (2569)) AS y(tax_year)
+CROSS JOIN (VALUES (0, 150000, 0),
+(150000, 500000, 0.1),
+(500000, 1000000, 0.15),
+(1000000, 2000000, 0.2),
+(2000000, NULL, 0.35)) AS b(lower_bound, upper_bound, rate);
+CREATE TABLE IF NOT EXISTS "tax_batches" (
+    id TEXT PRIMARY KEY,
+    status TEXT NOT NULL,
+    tax_year INTEGER NOT NULL DEFAULT 0,
+    header TEXT[] NOT NULL,
+    content BYTEA NOT NULL,
+    options JSONB NOT NULL DEFAULT '{}',
+    rules JSONB NOT NULL DEFAULT '{}',
+    total_rows INTEGER NOT NULL DEFAULT 0,
+    processed_rows INTEGER NOT NULL DEFAULT 0,
+    succeeded INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    summary JSONB,
+    lease_owner TEXT NOT NULL DEFAULT '',
+    lease_until TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS tax_batches_status_idx ON "tax_batches" (status, created_at);
+CREATE TABLE IF NOT EXISTS "tax_batch_results" (
+    batch_id TEXT NOT NULL REFERENCES "tax_batches" (id) ON DELETE CASCADE,
+    row_index INTEGER NOT NULL,
+    result JSONB NOT NULL,
+    PRIMARY KEY (batch_id, row_index)
+);
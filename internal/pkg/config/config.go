@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"strconv"
 )
@@ -25,12 +26,30 @@ type BasicCredential struct {
 	Password string
 }
 
+// Tax is how taxes are calculated. DonationMode is either "legacy" or
+// "statutory".
+type Tax struct {
+	DonationMode string
+}
+
+// Upload limits the files uploaded for calculation. Workers is the number of
+// rows of an upload calculated at once.
+type Upload struct {
+	MaxRows  int
+	MaxBytes int
+	Workers  int
+}
+
 const (
 	cHostname    = "HOSTNAME"
 	cPort        = "PORT"
 	cDatabaseUrl = "DATABASE_URL"
 	cUsername    = "ADMIN_USERNAME"
 	cPassword    = "ADMIN_PASSWORD"
+	cDonation    = "DONATION_MODE"
+	cMaxRows     = "UPLOAD_MAX_ROWS"
+	cMaxBytes    = "UPLOAD_MAX_BYTES"
+	cWorkers     = "UPLOAD_WORKERS"
 )
 
 func New() *cfg {
@@ -49,6 +68,19 @@ func (c *cfg) BasicCredential() BasicCredential {
 	return BasicCredential{c.envString(cUsername, "adminTax"), c.envString(cPassword, "admin!")}
 }
 
+// Tax returns an error when DONATION_MODE is neither legacy nor statutory.
+func (c *cfg) Tax() (Tax, error) {
+	mode := c.envString(cDonation, "legacy")
+	if mode != "legacy" && mode != "statutory" {
+		return Tax{}, fmt.Errorf("%s must be 'legacy' or 'statutory', got %q", cDonation, mode)
+	}
+	return Tax{mode}, nil
+}
+
+func (c *cfg) Upload() Upload {
+	return Upload{c.envInt(cMaxRows, 1000000), c.envInt(cMaxBytes, 100<<20), c.envInt(cWorkers, 4)}
+}
+
 func (c *cfg) envString(key, defaultValue string) string {
 	value := c.getEnv(key)
 	if value == "" {
@@ -2,9 +2,12 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/lib/pq"
 )
 
 func InitDB(DBUrl string) (*sql.DB, error) {
@@ -22,13 +25,239 @@ func InitDB(DBUrl string) (*sql.DB, error) {
 	return db, nil
 }
 
-func SetDeductionValue(db *sql.DB, key string, value float64) float64 {
-	db.QueryRow("INSERT INTO \"deductions\" (\"name\", maxAmount) VALUES ($1, $2) ON CONFLICT (\"name\") DO UPDATE SET maxAmount = EXCLUDED.maxAmount RETURNING maxAmount;", key, value).Scan(&value)
-	return value
+// Querier runs the queries reading the rules, on a *sql.DB or within a
+// *sql.Tx.
+type Querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
 }
 
-func GetDeductionValue(db *sql.DB, key string) float64 {
-	var value float64
-	db.QueryRow("SELECT 'maxAmount' FROM \"dedictions\" WHERE \"name\" = $1;", key).Scan(&value)
-	return value
+func SetDeductionValue(db *sql.DB, key string, value money.Amount) (money.Amount, error) {
+	err := db.QueryRow("INSERT INTO \"deductions\" (\"name\", maxAmount) VALUES ($1, $2) ON CONFLICT (\"name\") DO UPDATE SET maxAmount = EXCLUDED.maxAmount RETURNING maxAmount;", key, value).Scan(&value)
+	return value, err
+}
+
+func GetDeductionValue(db Querier, key string) (money.Amount, error) {
+	var value money.Amount
+	err := db.QueryRow("SELECT maxAmount FROM \"deductions\" WHERE \"name\" = $1;", key).Scan(&value)
+	return value, err
+}
+
+type TaxBracket struct {
+	LowerBound money.Amount  `json:"lowerBound"`
+	UpperBound *money.Amount `json:"upperBound"`
+	Rate       money.Rate    `json:"rate"`
+}
+
+func GetTaxBrackets(db Querier, taxYear int) ([]TaxBracket, error) {
+	rows, err := db.Query("SELECT lower_bound, upper_bound, rate FROM \"tax_brackets\" WHERE tax_year = $1 ORDER BY lower_bound;", taxYear)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	brackets := []TaxBracket{}
+	for rows.Next() {
+		bracket := TaxBracket{}
+		var upperBound sql.Null[money.Amount]
+		if err := rows.Scan(&bracket.LowerBound, &upperBound, &bracket.Rate); err != nil {
+			return nil, err
+		}
+		if upperBound.Valid {
+			bracket.UpperBound = &upperBound.V
+		}
+		brackets = append(brackets, bracket)
+	}
+	return brackets, rows.Err()
+}
+
+func ListTaxYears(db *sql.DB) ([]int, error) {
+	rows, err := db.Query("SELECT DISTINCT tax_year FROM \"tax_brackets\" ORDER BY tax_year;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	taxYears := []int{}
+	for rows.Next() {
+		var taxYear int
+		if err := rows.Scan(&taxYear); err != nil {
+			return nil, err
+		}
+		taxYears = append(taxYears, taxYear)
+	}
+	return taxYears, rows.Err()
+}
+
+// SetTaxBrackets replaces every bracket of the tax year in a single transaction.
+func SetTaxBrackets(db *sql.DB, taxYear int, brackets []TaxBracket) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM \"tax_brackets\" WHERE tax_year = $1;", taxYear); err != nil {
+		return err
+	}
+	for _, bracket := range brackets {
+		if _, err := tx.Exec("INSERT INTO \"tax_brackets\" (tax_year, lower_bound, upper_bound, rate) VALUES ($1, $2, $3, $4);", taxYear, bracket.LowerBound, bracket.UpperBound, bracket.Rate); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Statuses of a tax batch. A batch is pending until the worker claims it and
+// running until every row is calculated, it fails or it's cancelled.
+const (
+	BatchPending   = "pending"
+	BatchRunning   = "running"
+	BatchCompleted = "completed"
+	BatchFailed    = "failed"
+	BatchCancelled = "cancelled"
+)
+
+// ErrBatchNotRunning is returned when saving the progress of a batch that is no
+// longer running by the worker, e.g. cancelled meanwhile or claimed by another
+// worker after its lease ran out.
+var ErrBatchNotRunning = errors.New("the batch is not running")
+
+// TaxBatch is an uploaded file calculated in the background. Options tell how
+// to read the file, Rules are the rule sets its rows are calculated with and
+// Summary sums up the results of a completed batch, all as JSON. Content,
+// Options and Rules are only loaded when claiming the batch.
+type TaxBatch struct {
+	ID            string
+	Status        string
+	TaxYear       int
+	Header        []string
+	Content       []byte
+	Options       []byte
+	Rules         []byte
+	TotalRows     int
+	ProcessedRows int
+	Succeeded     int
+	Failed        int
+	Error         string
+	Summary       []byte
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// TaxBatchResult is the result of the row at Index of a batch, as JSON.
+type TaxBatchResult struct {
+	Index  int
+	Result []byte
+}
+
+const taxBatchColumns = "id, status, tax_year, header, total_rows, processed_rows, succeeded, failed, error, summary, created_at, updated_at"
+
+func scanTaxBatch(row *sql.Row) (TaxBatch, error) {
+	batch := TaxBatch{}
+	err := row.Scan(&batch.ID, &batch.Status, &batch.TaxYear, pq.Array(&batch.Header), &batch.TotalRows, &batch.ProcessedRows, &batch.Succeeded, &batch.Failed, &batch.Error, &batch.Summary, &batch.CreatedAt, &batch.UpdatedAt)
+	return batch, err
+}
+
+func CreateTaxBatch(db *sql.DB, batch TaxBatch) (TaxBatch, error) {
+	return scanTaxBatch(db.QueryRow("INSERT INTO \"tax_batches\" (id, status, tax_year, header, content, options, rules, total_rows) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING "+taxBatchColumns+";", batch.ID, BatchPending, batch.TaxYear, pq.Array(batch.Header), batch.Content, batch.Options, batch.Rules, batch.TotalRows))
+}
+
+func GetTaxBatch(db *sql.DB, id string) (TaxBatch, error) {
+	return scanTaxBatch(db.QueryRow("SELECT "+taxBatchColumns+" FROM \"tax_batches\" WHERE id = $1;", id))
+}
+
+// GetTaxBatchRules returns the rules a batch is calculated with.
+func GetTaxBatchRules(db *sql.DB, id string) ([]byte, error) {
+	var rules []byte
+	err := db.QueryRow("SELECT rules FROM \"tax_batches\" WHERE id = $1;", id).Scan(&rules)
+	return rules, err
+}
+
+// CancelTaxBatch cancels a pending or running batch. It returns sql.ErrNoRows
+// when there's no such batch or it has already ended.
+func CancelTaxBatch(db *sql.DB, id string) (TaxBatch, error) {
+	return scanTaxBatch(db.QueryRow("UPDATE \"tax_batches\" SET status = $2, updated_at = now() WHERE id = $1 AND status IN ($3, $4) RETURNING "+taxBatchColumns+";", id, BatchCancelled, BatchPending, BatchRunning))
+}
+
+// ClaimTaxBatch marks the oldest pending batch, or running batch whose lease ran
+// out, as running by owner for lease and returns it with its content, options
+// and rules. It returns sql.ErrNoRows when no batch is left to claim.
+func ClaimTaxBatch(db *sql.DB, owner string, lease time.Duration) (TaxBatch, error) {
+	batch := TaxBatch{}
+	err := db.QueryRow("UPDATE \"tax_batches\" SET status = $1, lease_owner = $3, lease_until = now() + make_interval(secs => $4), updated_at = now() WHERE id = (SELECT id FROM \"tax_batches\" WHERE status = $2 OR (status = $1 AND lease_until < now()) ORDER BY created_at LIMIT 1 FOR UPDATE SKIP LOCKED) RETURNING id, tax_year, content, options, rules, total_rows, processed_rows;", BatchRunning, BatchPending, owner, lease.Seconds()).Scan(&batch.ID, &batch.TaxYear, &batch.Content, &batch.Options, &batch.Rules, &batch.TotalRows, &batch.ProcessedRows)
+	batch.Status = BatchRunning
+	return batch, err
+}
+
+// RenewTaxBatch extends the lease of owner on a running batch. It returns
+// ErrBatchNotRunning when the batch is no longer running by owner.
+func RenewTaxBatch(db *sql.DB, id string, owner string, lease time.Duration) error {
+	res, err := db.Exec("UPDATE \"tax_batches\" SET lease_until = now() + make_interval(secs => $3) WHERE id = $1 AND lease_owner = $2 AND status = $4;", id, owner, lease.Seconds(), BatchRunning)
+	return checkRunning(res, err)
+}
+
+// checkRunning returns ErrBatchNotRunning when the update of a running batch
+// affected no row.
+func checkRunning(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrBatchNotRunning
+	}
+	return nil
+}
+
+// SaveTaxBatchResults stores the results of the next rows of a batch running by
+// owner and counts them in its progress in a single transaction.
+func SaveTaxBatchResults(db *sql.DB, id string, owner string, results []TaxBatchResult, succeeded, failed int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE \"tax_batches\" SET processed_rows = processed_rows + $2, succeeded = succeeded + $3, failed = failed + $4, updated_at = now() WHERE id = $1 AND lease_owner = $6 AND status = $5;", id, len(results), succeeded, failed, BatchRunning, owner)
+	if err := checkRunning(res, err); err != nil {
+		return err
+	}
+	for _, result := range results {
+		if _, err := tx.Exec("INSERT INTO \"tax_batch_results\" (batch_id, row_index, result) VALUES ($1, $2, $3);", id, result.Index, result.Result); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// FinishTaxBatch ends a batch running by owner with status, and summary when
+// it's completed.
+func FinishTaxBatch(db *sql.DB, id string, owner string, status string, message string, summary []byte) error {
+	_, err := db.Exec("UPDATE \"tax_batches\" SET status = $2, error = $3, summary = $4, updated_at = now() WHERE id = $1 AND lease_owner = $6 AND status = $5;", id, status, message, summary, BatchRunning, owner)
+	return err
+}
+
+// EachTaxBatchResult calls fn with the result of each row of a batch in the
+// order of the rows, without loading every result at once.
+func EachTaxBatchResult(db *sql.DB, id string, fn func(result []byte) error) error {
+	rows, err := db.Query("SELECT result FROM \"tax_batch_results\" WHERE batch_id = $1 ORDER BY row_index;", id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var result []byte
+		if err := rows.Scan(&result); err != nil {
+			return err
+		}
+		if err := fn(result); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
@@ -3,8 +3,10 @@ package db
 import (
 	"database/sql"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -13,32 +15,210 @@ func TestGetDeductionValue(t *testing.T) {
 	testCases := []struct {
 		key      string
 		sqlFn    func() (*sql.DB, error)
-		expected float64
+		expected money.Amount
 	}{{"personal", func() (*sql.DB, error) {
 		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 		if err != nil {
 			return nil, err
 		}
 		row := sqlmock.NewRows([]string{"maxAmount"}).AddRow(60000.0)
-		mock.ExpectQuery("SELECT 'maxAmount' FROM \"dedictions\" WHERE \"name\" = $1;").WithArgs("personal").WillReturnRows(row)
+		mock.ExpectQuery("SELECT maxAmount FROM \"deductions\" WHERE \"name\" = $1;").WithArgs("personal").WillReturnRows(row)
 		return db, err
-	}, 60000.0}, {"k-receipt", func() (*sql.DB, error) {
+	}, money.New(60000)}, {"k-receipt", func() (*sql.DB, error) {
 		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 		if err != nil {
 			return nil, err
 		}
 		row := sqlmock.NewRows([]string{"maxAmount"}).AddRow(50000.0)
-		mock.ExpectQuery("SELECT 'maxAmount' FROM \"dedictions\" WHERE \"name\" = $1;").WithArgs("k-receipt").WillReturnRows(row)
+		mock.ExpectQuery("SELECT maxAmount FROM \"deductions\" WHERE \"name\" = $1;").WithArgs("k-receipt").WillReturnRows(row)
 		return db, err
-	}, 50000.0}}
+	}, money.New(50000)}}
 
 	// Act & Assert
 	for _, tc := range testCases {
 		db, err := tc.sqlFn()
-		actualMaxAmount := GetDeductionValue(db, tc.key)
+		actualMaxAmount, qerr := GetDeductionValue(db, tc.key)
 
 		assert.NoError(t, err)
+		assert.NoError(t, qerr)
 		assert.Equal(t, tc.expected, actualMaxAmount)
 	}
 
 }
+
+func TestErrorGetDeductionValue(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	assert.NoError(t, err)
+	mock.ExpectQuery("SELECT maxAmount FROM \"deductions\" WHERE \"name\" = $1;").WithArgs("personal").WillReturnError(sql.ErrConnDone)
+
+	// Act
+	_, err = GetDeductionValue(db, "personal")
+
+	// Assert
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+}
+
+func TestSetDeductionValue(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	assert.NoError(t, err)
+	row := sqlmock.NewRows([]string{"maxAmount"}).AddRow("70000.00")
+	mock.ExpectQuery("INSERT INTO \"deductions\" (\"name\", maxAmount) VALUES ($1, $2) ON CONFLICT (\"name\") DO UPDATE SET maxAmount = EXCLUDED.maxAmount RETURNING maxAmount;").WithArgs("personal", "70000.00").WillReturnRows(row)
+
+	// Act
+	value, err := SetDeductionValue(db, "personal", money.New(70000))
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(70000), value)
+}
+
+func TestGetTaxBrackets(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	assert.NoError(t, err)
+	rows := sqlmock.NewRows([]string{"lower_bound", "upper_bound", "rate"}).AddRow(0.0, 150000.0, 0.0).AddRow(150000.0, nil, 0.1)
+	mock.ExpectQuery("SELECT lower_bound, upper_bound, rate FROM \"tax_brackets\" WHERE tax_year = $1 ORDER BY lower_bound;").WithArgs(2567).WillReturnRows(rows)
+
+	// Act
+	brackets, err := GetTaxBrackets(db, 2567)
+
+	// Assert
+	upper := money.New(150000)
+	assert.NoError(t, err)
+	assert.Equal(t, []TaxBracket{{0, &upper, 0}, {money.New(150000), nil, money.RateFromFloat(0.1)}}, brackets)
+}
+
+func TestSetTaxBrackets(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	assert.NoError(t, err)
+	upper := money.New(150000)
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM \"tax_brackets\" WHERE tax_year = $1;").WithArgs(2567).WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectExec("INSERT INTO \"tax_brackets\" (tax_year, lower_bound, upper_bound, rate) VALUES ($1, $2, $3, $4);").WithArgs(2567, "0.00", "150000.00", "0").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO \"tax_brackets\" (tax_year, lower_bound, upper_bound, rate) VALUES ($1, $2, $3, $4);").WithArgs(2567, "150000.00", nil, "0.1").WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	// Act
+	err = SetTaxBrackets(db, 2567, []TaxBracket{{0, &upper, 0}, {money.New(150000), nil, money.RateFromFloat(0.1)}})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCancelTaxBatch(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	assert.NoError(t, err)
+	now := time.Now()
+	rows := sqlmock.NewRows([]string{"id", "status", "tax_year", "header", "total_rows", "processed_rows", "succeeded", "failed", "error", "summary", "created_at", "updated_at"}).AddRow("abc", BatchCancelled, 2567, "{id,totalIncome}", 10, 4, 3, 1, "", nil, now, now)
+	mock.ExpectQuery("UPDATE \"tax_batches\" SET status = $2, updated_at = now() WHERE id = $1 AND status IN ($3, $4) RETURNING "+taxBatchColumns+";").WithArgs("abc", BatchCancelled, BatchPending, BatchRunning).WillReturnRows(rows)
+
+	// Act
+	batch, err := CancelTaxBatch(db, "abc")
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, TaxBatch{ID: "abc", Status: BatchCancelled, TaxYear: 2567, Header: []string{"id", "totalIncome"}, TotalRows: 10, ProcessedRows: 4, Succeeded: 3, Failed: 1, CreatedAt: now, UpdatedAt: now}, batch)
+}
+
+func TestClaimTaxBatch(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	assert.NoError(t, err)
+	rows := sqlmock.NewRows([]string{"id", "tax_year", "content", "options", "rules", "total_rows", "processed_rows"}).AddRow("abc", 0, []byte("totalIncome\n1.0\n"), []byte("{}"), []byte("{}"), 1, 0)
+	mock.ExpectQuery("UPDATE \"tax_batches\" SET status = $1, lease_owner = $3, lease_until = now() + make_interval(secs => $4), updated_at = now() WHERE id = (SELECT id FROM \"tax_batches\" WHERE status = $2 OR (status = $1 AND lease_until < now()) ORDER BY created_at LIMIT 1 FOR UPDATE SKIP LOCKED) RETURNING id, tax_year, content, options, rules, total_rows, processed_rows;").WithArgs(BatchRunning, BatchPending, "w1", 30.0).WillReturnRows(rows)
+
+	// Act
+	batch, err := ClaimTaxBatch(db, "w1", 30*time.Second)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, TaxBatch{ID: "abc", Status: BatchRunning, Content: []byte("totalIncome\n1.0\n"), Options: []byte("{}"), Rules: []byte("{}"), TotalRows: 1}, batch)
+}
+
+func TestRenewTaxBatch(t *testing.T) {
+	testCases := []struct {
+		affected int64
+		expected error
+	}{{1, nil}, {0, ErrBatchNotRunning}}
+
+	for _, tc := range testCases {
+		// Arrange
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		assert.NoError(t, err)
+		mock.ExpectExec("UPDATE \"tax_batches\" SET lease_until = now() + make_interval(secs => $3) WHERE id = $1 AND lease_owner = $2 AND status = $4;").WithArgs("abc", "w1", 30.0, BatchRunning).WillReturnResult(sqlmock.NewResult(0, tc.affected))
+
+		// Act
+		err = RenewTaxBatch(db, "abc", "w1", 30*time.Second)
+
+		// Assert
+		assert.Equal(t, tc.expected, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	}
+}
+
+func TestSaveTaxBatchResults(t *testing.T) {
+	testCases := []struct {
+		affected int64
+		expected error
+	}{{1, nil}, {0, ErrBatchNotRunning}}
+
+	for _, tc := range testCases {
+		// Arrange
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		assert.NoError(t, err)
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE \"tax_batches\" SET processed_rows = processed_rows + $2, succeeded = succeeded + $3, failed = failed + $4, updated_at = now() WHERE id = $1 AND lease_owner = $6 AND status = $5;").WithArgs("abc", 2, 1, 1, BatchRunning, "w1").WillReturnResult(sqlmock.NewResult(0, tc.affected))
+		if tc.expected == nil {
+			mock.ExpectExec("INSERT INTO \"tax_batch_results\" (batch_id, row_index, result) VALUES ($1, $2, $3);").WithArgs("abc", 4, []byte(`{"line":6}`)).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("INSERT INTO \"tax_batch_results\" (batch_id, row_index, result) VALUES ($1, $2, $3);").WithArgs("abc", 5, []byte(`{"line":7}`)).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		} else {
+			mock.ExpectRollback()
+		}
+
+		// Act
+		err = SaveTaxBatchResults(db, "abc", "w1", []TaxBatchResult{{4, []byte(`{"line":6}`)}, {5, []byte(`{"line":7}`)}}, 1, 1)
+
+		// Assert
+		assert.Equal(t, tc.expected, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	}
+}
+
+func TestFinishTaxBatch(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	assert.NoError(t, err)
+	mock.ExpectExec("UPDATE \"tax_batches\" SET status = $2, error = $3, summary = $4, updated_at = now() WHERE id = $1 AND lease_owner = $6 AND status = $5;").WithArgs("abc", BatchCompleted, "", []byte(`{"total":1}`), BatchRunning, "w1").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Act
+	err = FinishTaxBatch(db, "abc", "w1", BatchCompleted, "", []byte(`{"total":1}`))
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEachTaxBatchResult(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	assert.NoError(t, err)
+	rows := sqlmock.NewRows([]string{"result"}).AddRow([]byte(`{"line":2}`)).AddRow([]byte(`{"line":3}`))
+	mock.ExpectQuery("SELECT result FROM \"tax_batch_results\" WHERE batch_id = $1 ORDER BY row_index;").WithArgs("abc").WillReturnRows(rows)
+
+	// Act
+	results := []string{}
+	err = EachTaxBatchResult(db, "abc", func(result []byte) error {
+		results = append(results, string(result))
+		return nil
+	})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`{"line":2}`, `{"line":3}`}, results)
+}
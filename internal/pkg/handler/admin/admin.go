@@ -2,19 +2,26 @@ package admin
 
 import (
 	"database/sql"
+	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/kidkrub/assessment-tax/internal/pkg/db"
+	"github.com/kidkrub/assessment-tax/internal/pkg/handler/tax"
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
 	"github.com/labstack/echo/v4"
 )
 
 type SetDuctionRequestObject struct {
-	Amount float64 `json:"amount"`
+	Amount money.Amount `json:"amount"`
 }
 
 type SetDuctionResponseObject struct {
-	PersonalDeduction float64 `json:"personalDeduction,omitempty"`
-	KReceipt          float64 `json:"kReceipt,omitempty"`
+	PersonalDeduction money.Amount `json:"personalDeduction,omitempty"`
+	KReceipt          money.Amount `json:"kReceipt,omitempty"`
 }
 
 type handler struct {
@@ -29,20 +36,23 @@ func (h handler) SetDeductionValueHandler(c echo.Context) error {
 	dType := c.Param("type")
 	setDuctionRequestObject := SetDuctionRequestObject{}
 	if err := c.Bind(&setDuctionRequestObject); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "bad request body", err.Error())
+		return problem.Bind(err)
 	}
 	if dType == "personal" {
-		if setDuctionRequestObject.Amount > 100000 || setDuctionRequestObject.Amount < 10000 {
-			return echo.NewHTTPError(http.StatusBadRequest, "amount must between 10,000 - 100,000")
+		if setDuctionRequestObject.Amount > money.New(100000) || setDuctionRequestObject.Amount < money.New(10000) {
+			return problem.New(problem.BadRequest, "amount must between 10,000 - 100,000")
 		}
 	}
 	if dType == "k-receipt" {
-		if setDuctionRequestObject.Amount > 100000 || setDuctionRequestObject.Amount < 0 {
-			return echo.NewHTTPError(http.StatusBadRequest, "amount must between 0 - 100,000")
+		if setDuctionRequestObject.Amount > money.New(100000) || setDuctionRequestObject.Amount < 0 {
+			return problem.New(problem.BadRequest, "amount must between 0 - 100,000")
 		}
 	}
 
-	value := db.SetDeductionValue(h.db, dType, setDuctionRequestObject.Amount)
+	value, err := db.SetDeductionValue(h.db, dType, setDuctionRequestObject.Amount)
+	if err != nil {
+		return problem.Wrap(err)
+	}
 	res := SetDuctionResponseObject{}
 	if dType == "personal" {
 		res.PersonalDeduction = value
@@ -52,3 +62,133 @@ func (h handler) SetDeductionValueHandler(c echo.Context) error {
 	}
 	return c.JSON(http.StatusOK, res)
 }
+
+type TaxBracketsRequestObject struct {
+	Brackets []db.TaxBracket `json:"brackets"`
+}
+
+type TaxBracketsResponseObject struct {
+	TaxYear  int             `json:"taxYear"`
+	Brackets []db.TaxBracket `json:"brackets"`
+}
+
+type ValidateTaxBracketsResponseObject struct {
+	Valid  bool                 `json:"valid"`
+	Errors []problem.FieldError `json:"errors"`
+}
+
+var invalidTaxBrackets = problem.NewType("invalid-tax-brackets", "Invalid tax brackets", http.StatusBadRequest)
+
+const (
+	codeRequired      = "required"
+	codeOutOfRange    = "outOfRange"
+	codeNotAllowed    = "notAllowed"
+	codeNotIncreasing = "notIncreasing"
+	codeNotContiguous = "notContiguous"
+)
+
+func (h handler) ListTaxBracketsHandler(c echo.Context) error {
+	taxYears, err := db.ListTaxYears(h.db)
+	if err != nil {
+		return problem.Wrap(err)
+	}
+	res := []TaxBracketsResponseObject{}
+	for _, taxYear := range taxYears {
+		brackets, err := db.GetTaxBrackets(h.db, taxYear)
+		if err != nil {
+			return problem.Wrap(err)
+		}
+		res = append(res, TaxBracketsResponseObject{taxYear, brackets})
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h handler) GetTaxBracketsHandler(c echo.Context) error {
+	taxYear, err := strconv.Atoi(c.Param("year"))
+	if err != nil {
+		return problem.New(problem.BadRequest, "tax year must be a number")
+	}
+	brackets, err := db.GetTaxBrackets(h.db, taxYear)
+	if err != nil {
+		return problem.Wrap(err)
+	}
+	if len(brackets) == 0 {
+		return problem.New(problem.NotFound, "no tax brackets for this tax year")
+	}
+	return c.JSON(http.StatusOK, TaxBracketsResponseObject{taxYear, brackets})
+}
+
+// SetTaxBracketsHandler replaces the brackets of a tax year. Only years with a
+// rule set are accepted, as brackets of other years would never be used.
+func (h handler) SetTaxBracketsHandler(c echo.Context) error {
+	taxYear, err := strconv.Atoi(c.Param("year"))
+	if err != nil {
+		return problem.New(problem.BadRequest, "tax year must be a number")
+	}
+	if taxYears := tax.TaxYears(); !slices.Contains(taxYears, taxYear) {
+		return problem.Newf(problem.BadRequest, "unsupported tax year %d, the supported tax years are %s", taxYear, joinYears(taxYears))
+	}
+	taxBracketsRequestObject := TaxBracketsRequestObject{}
+	if err := c.Bind(&taxBracketsRequestObject); err != nil {
+		return problem.Bind(err)
+	}
+	if errs := validateTaxBrackets(taxBracketsRequestObject.Brackets); len(errs) > 0 {
+		return problem.New(invalidTaxBrackets, "the tax brackets are invalid").WithErrors(errs)
+	}
+	if err := db.SetTaxBrackets(h.db, taxYear, taxBracketsRequestObject.Brackets); err != nil {
+		return problem.Wrap(err)
+	}
+	return c.JSON(http.StatusOK, TaxBracketsResponseObject{taxYear, taxBracketsRequestObject.Brackets})
+}
+
+func joinYears(years []int) string {
+	values := []string{}
+	for _, year := range years {
+		values = append(values, strconv.Itoa(year))
+	}
+	return strings.Join(values, ", ")
+}
+
+func (h handler) ValidateTaxBracketsHandler(c echo.Context) error {
+	taxBracketsRequestObject := TaxBracketsRequestObject{}
+	if err := c.Bind(&taxBracketsRequestObject); err != nil {
+		return problem.Bind(err)
+	}
+	errs := validateTaxBrackets(taxBracketsRequestObject.Brackets)
+	return c.JSON(http.StatusOK, ValidateTaxBracketsResponseObject{len(errs) == 0, errs})
+}
+
+// validateTaxBrackets checks that the brackets start at 0, are contiguous and
+// non-overlapping, have rates between 0 and 1 and end with an open-ended tier.
+func validateTaxBrackets(brackets []db.TaxBracket) []problem.FieldError {
+	errs := []problem.FieldError{}
+	if len(brackets) == 0 {
+		return append(errs, problem.NewFieldError("brackets", codeRequired, "at least one bracket is required"))
+	}
+	if brackets[0].LowerBound != 0 {
+		errs = append(errs, problem.NewFieldError("brackets[0].lowerBound", codeOutOfRange, "brackets[0].lowerBound must be 0"))
+	}
+	for i, bracket := range brackets {
+		field := fmt.Sprintf("brackets[%d].", i)
+		if bracket.Rate < 0 || bracket.Rate > money.OneRate {
+			errs = append(errs, problem.NewFieldError(field+"rate", codeOutOfRange, field+"rate must between 0 - 1"))
+		}
+		if i == len(brackets)-1 {
+			if bracket.UpperBound != nil {
+				errs = append(errs, problem.NewFieldError(field+"upperBound", codeNotAllowed, field+"upperBound must be null for the top tier"))
+			}
+			continue
+		}
+		if bracket.UpperBound == nil {
+			errs = append(errs, problem.NewFieldError(field+"upperBound", codeRequired, field+"upperBound is required below the top tier"))
+			continue
+		}
+		if *bracket.UpperBound <= bracket.LowerBound {
+			errs = append(errs, problem.NewFieldError(field+"upperBound", codeNotIncreasing, field+"upperBound must be greater than lowerBound"))
+		}
+		if brackets[i+1].LowerBound != *bracket.UpperBound {
+			errs = append(errs, problem.NewFieldError(fmt.Sprintf("brackets[%d].lowerBound", i+1), codeNotContiguous, fmt.Sprintf("brackets[%d].lowerBound must equal brackets[%d].upperBound", i+1, i)))
+		}
+	}
+	return errs
+}
@@ -8,6 +8,9 @@ import (
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/kidkrub/assessment-tax/internal/pkg/db"
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 )
@@ -26,7 +29,7 @@ func TestSetDeductionValueHandler(t *testing.T) {
 				return nil, err
 			}
 			row := sqlmock.NewRows([]string{"maxAmount"}).AddRow(70000.0)
-			mock.ExpectQuery("INSERT INTO \"deductions\" (\"name\", maxAmount) VALUES ($1, $2) ON CONFLICT (\"name\") DO UPDATE SET maxAmount = EXCLUDED.maxAmount RETURNING maxAmount;").WithArgs("personal", 70000.0).WillReturnRows(row)
+			mock.ExpectQuery("INSERT INTO \"deductions\" (\"name\", maxAmount) VALUES ($1, $2) ON CONFLICT (\"name\") DO UPDATE SET maxAmount = EXCLUDED.maxAmount RETURNING maxAmount;").WithArgs("personal", "70000.00").WillReturnRows(row)
 			return db, err
 		}, `{"personalDeduction":70000.0}`},
 		{"k-receipt", `{"amount":80000.0}`, func() (*sql.DB, error) {
@@ -35,7 +38,7 @@ func TestSetDeductionValueHandler(t *testing.T) {
 				return nil, err
 			}
 			row := sqlmock.NewRows([]string{"maxAmount"}).AddRow(80000.0)
-			mock.ExpectQuery("INSERT INTO \"deductions\" (\"name\", maxAmount) VALUES ($1, $2) ON CONFLICT (\"name\") DO UPDATE SET maxAmount = EXCLUDED.maxAmount RETURNING maxAmount;").WithArgs("k-receipt", 80000.0).WillReturnRows(row)
+			mock.ExpectQuery("INSERT INTO \"deductions\" (\"name\", maxAmount) VALUES ($1, $2) ON CONFLICT (\"name\") DO UPDATE SET maxAmount = EXCLUDED.maxAmount RETURNING maxAmount;").WithArgs("k-receipt", "80000.00").WillReturnRows(row)
 			return db, err
 		}, `{"kReceipt":80000.0}`},
 	}
@@ -76,28 +79,28 @@ func TestErrorSetDeductionValueHandler(t *testing.T) {
 				return nil, err
 			}
 			return db, err
-		}, echo.NewHTTPError(http.StatusBadRequest, "amount must between 10,000 - 100,000")},
+		}, problem.New(problem.BadRequest, "amount must between 10,000 - 100,000")},
 		{"personal", `{"amount":100001.0}`, func() (*sql.DB, error) {
 			db, _, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 			if err != nil {
 				return nil, err
 			}
 			return db, err
-		}, echo.NewHTTPError(http.StatusBadRequest, "amount must between 10,000 - 100,000")},
+		}, problem.New(problem.BadRequest, "amount must between 10,000 - 100,000")},
 		{"k-receipt", `{"amount":-1.0}`, func() (*sql.DB, error) {
 			db, _, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 			if err != nil {
 				return nil, err
 			}
 			return db, err
-		}, echo.NewHTTPError(http.StatusBadRequest, "amount must between 0 - 100,000")},
+		}, problem.New(problem.BadRequest, "amount must between 0 - 100,000")},
 		{"k-receipt", `{"amount":100001.0}`, func() (*sql.DB, error) {
 			db, _, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 			if err != nil {
 				return nil, err
 			}
 			return db, err
-		}, echo.NewHTTPError(http.StatusBadRequest, "amount must between 0 - 100,000")},
+		}, problem.New(problem.BadRequest, "amount must between 0 - 100,000")},
 	}
 
 	for _, tc := range testCases {
@@ -123,3 +126,106 @@ func TestErrorSetDeductionValueHandler(t *testing.T) {
 
 	}
 }
+
+func TestValidateTaxBrackets(t *testing.T) {
+	upper := func(value float64) *money.Amount {
+		amount := money.FromFloat(value)
+		return &amount
+	}
+	bracket := func(lower float64, upper *money.Amount, rate float64) db.TaxBracket {
+		return db.TaxBracket{LowerBound: money.FromFloat(lower), UpperBound: upper, Rate: money.RateFromFloat(rate)}
+	}
+	testCases := []struct {
+		brackets []db.TaxBracket
+		expected []string
+	}{
+		{[]db.TaxBracket{bracket(0, upper(150000), 0), bracket(150000, upper(500000), 0.1), bracket(500000, nil, 0.35)}, []string{}},
+		{[]db.TaxBracket{}, []string{"at least one bracket is required"}},
+		{[]db.TaxBracket{bracket(100, upper(150000), 0), bracket(150000, nil, 0.1)}, []string{"brackets[0].lowerBound must be 0"}},
+		{[]db.TaxBracket{bracket(0, upper(150000), 0), bracket(140000, nil, 0.1)}, []string{"brackets[1].lowerBound must equal brackets[0].upperBound"}},
+		{[]db.TaxBracket{bracket(0, upper(150000), 0), bracket(160000, nil, 0.1)}, []string{"brackets[1].lowerBound must equal brackets[0].upperBound"}},
+		{[]db.TaxBracket{bracket(0, upper(0), 0), bracket(0, nil, 0.1)}, []string{"brackets[0].upperBound must be greater than lowerBound"}},
+		{[]db.TaxBracket{bracket(0, upper(150000), -0.1), bracket(150000, nil, 1.1)}, []string{"brackets[0].rate must between 0 - 1", "brackets[1].rate must between 0 - 1"}},
+		{[]db.TaxBracket{bracket(0, nil, 0), bracket(150000, upper(500000), 0.1)}, []string{"brackets[0].upperBound is required below the top tier", "brackets[1].upperBound must be null for the top tier"}},
+	}
+
+	for _, tc := range testCases {
+		messages := []string{}
+		for _, err := range validateTaxBrackets(tc.brackets) {
+			messages = append(messages, err.Message)
+		}
+		assert.Equal(t, tc.expected, messages)
+	}
+}
+
+func TestSetTaxBracketsHandler(t *testing.T) {
+	// Arrange
+	reqBody := `{"brackets":[{"lowerBound":0,"upperBound":150000,"rate":0},{"lowerBound":150000,"upperBound":null,"rate":0.1}]}`
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(reqBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/:year")
+	c.SetParamNames("year")
+	c.SetParamValues("2567")
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM \"tax_brackets\" WHERE tax_year = $1;").WithArgs(2567).WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectExec("INSERT INTO \"tax_brackets\" (tax_year, lower_bound, upper_bound, rate) VALUES ($1, $2, $3, $4);").WithArgs(2567, "0.00", "150000.00", "0").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO \"tax_brackets\" (tax_year, lower_bound, upper_bound, rate) VALUES ($1, $2, $3, $4);").WithArgs(2567, "150000.00", nil, "0.1").WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+	h := New(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.SetTaxBracketsHandler(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"taxYear":2567,"brackets":[{"lowerBound":0,"upperBound":150000,"rate":0},{"lowerBound":150000,"upperBound":null,"rate":0.1}]}`, rec.Body.String())
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestErrorSetTaxBracketsHandler(t *testing.T) {
+	// Arrange
+	reqBody := `{"brackets":[{"lowerBound":0,"upperBound":150000,"rate":0},{"lowerBound":150000,"upperBound":500000,"rate":0.1}]}`
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(reqBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/:year")
+	c.SetParamNames("year")
+	c.SetParamValues("2567")
+
+	db, _, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	h := New(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, problem.New(invalidTaxBrackets, "the tax brackets are invalid").WithErrors([]problem.FieldError{
+		problem.NewFieldError("brackets[1].upperBound", codeNotAllowed, "brackets[1].upperBound must be null for the top tier"),
+	}), h.SetTaxBracketsHandler(c))
+}
+
+func TestErrorSetTaxBracketsHandlerUnsupportedTaxYear(t *testing.T) {
+	// Arrange
+	reqBody := `{"brackets":[{"lowerBound":0,"upperBound":150000,"rate":0},{"lowerBound":150000,"upperBound":null,"rate":0.1}]}`
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(reqBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/:year")
+	c.SetParamNames("year")
+	c.SetParamValues("2570")
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	h := New(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, problem.New(problem.BadRequest, "unsupported tax year 2570, the supported tax years are 2566, 2567, 2568, 2569"), h.SetTaxBracketsHandler(c))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,139 @@
+package tax
+
+import "github.com/kidkrub/assessment-tax/internal/pkg/money"
+
+type allowanceRule struct {
+	cap money.Amount
+	// setting takes the cap from the deduction of that name the admin configures
+	setting string
+	// incomeRate further caps the allowance at a share of the total income
+	incomeRate money.Rate
+	// perPerson multiplies the cap by the count claimed, up to maxCount when set
+	perPerson bool
+	maxCount  int
+	// group names the combined cap the allowance counts towards
+	group string
+	// donation allowances are capped together by the donation rules, double
+	// ones count twice the amount given
+	donation bool
+	double   bool
+}
+
+// allowanceTypes lists every supported allowance in the order they are applied.
+var allowanceTypes = []string{
+	"donation",
+	"education-donation",
+	"sports-donation",
+	"hospital-donation",
+	"political-donation",
+	"k-receipt",
+	"social-security",
+	"life-insurance",
+	"health-insurance",
+	"parents-health-insurance",
+	"provident-fund",
+	"rmf",
+	"ssf",
+	"thai-esg",
+	"home-loan-interest",
+	"spouse",
+	"child",
+	"parent-care",
+}
+
+var allowanceGroups = []string{"insurance", "retirement"}
+
+// donationTypes lists the donations in the order they are deducted, the double
+// donations before the others.
+var donationTypes = []string{"education-donation", "sports-donation", "hospital-donation", "donation"}
+
+const (
+	// donationModeLegacy caps every donation together at the flat cap of
+	// donation, donationModeStatutory at a share of the income left after the
+	// other allowances.
+	donationModeLegacy    = "legacy"
+	donationModeStatutory = "statutory"
+)
+
+func newAllowanceRules(thaiESGCap int64) map[string]allowanceRule {
+	return map[string]allowanceRule{
+		"donation":                 {cap: money.New(100000), donation: true},
+		"education-donation":       {donation: true, double: true},
+		"sports-donation":          {donation: true, double: true},
+		"hospital-donation":        {donation: true, double: true},
+		"political-donation":       {cap: money.New(10000)},
+		"k-receipt":                {setting: "k-receipt"},
+		"social-security":          {cap: money.New(9000)},
+		"life-insurance":           {cap: money.New(100000), group: "insurance"},
+		"health-insurance":         {cap: money.New(25000), group: "insurance"},
+		"parents-health-insurance": {cap: money.New(15000)},
+		"provident-fund":           {cap: money.New(500000), incomeRate: money.RateFromFloat(0.15), group: "retirement"},
+		"rmf":                      {cap: money.New(500000), incomeRate: money.RateFromFloat(0.3), group: "retirement"},
+		"ssf":                      {cap: money.New(200000), incomeRate: money.RateFromFloat(0.3), group: "retirement"},
+		"thai-esg":                 {cap: money.New(thaiESGCap), incomeRate: money.RateFromFloat(0.3)},
+		"home-loan-interest":       {cap: money.New(100000)},
+		"spouse":                   {cap: money.New(60000)},
+		"child":                    {cap: money.New(30000), perPerson: true},
+		"parent-care":              {cap: money.New(30000), perPerson: true, maxCount: 4},
+	}
+}
+
+var allowanceGroupCaps = map[string]money.Amount{
+	"insurance":  money.New(100000),
+	"retirement": money.New(500000),
+}
+
+// allowanceCap returns the most of an allowance type that can be deducted
+// before any group cap applies.
+func (rule TaxRule) allowanceCap(allowanceType string, count int, totalIncome money.Amount) money.Amount {
+	allowance := rule.Allowances[allowanceType]
+	cap := allowance.cap
+	if allowance.setting != "" {
+		cap = rule.Deductions[allowance.setting]
+	}
+	if allowance.perPerson {
+		count = max(count, 1)
+		if allowance.maxCount > 0 {
+			count = min(count, allowance.maxCount)
+		}
+		cap *= money.Amount(count)
+	}
+	if allowance.incomeRate > 0 {
+		cap = money.Min(cap, totalIncome.Mul(allowance.incomeRate))
+	}
+	return cap
+}
+
+// donationSteps deducts the donations claimed. Under the statutory rules the
+// double donations are capped together at DonationRate of the income left
+// after the other allowances, then the other donations at DonationRate of what
+// is left after the double ones. The legacy rules cap all of them together at
+// the flat cap of donation.
+func (rule TaxRule) donationSteps(requested map[string]money.Amount, taxable money.Amount) []CalculationStep {
+	statutory := rule.DonationMode == donationModeStatutory
+	cap := rule.Allowances["donation"].cap
+	if statutory {
+		cap = money.Max(taxable, 0).Mul(rule.DonationRate)
+	}
+	steps := []CalculationStep{}
+	double := true
+	for _, allowanceType := range donationTypes {
+		amount, ok := requested[allowanceType]
+		if !ok {
+			continue
+		}
+		allowance := rule.Allowances[allowanceType]
+		if statutory && double && !allowance.double {
+			cap = money.Max(taxable, 0).Mul(rule.DonationRate)
+		}
+		double = allowance.double
+		if allowance.double {
+			amount *= 2
+		}
+		step := allowanceStep(allowanceType, amount, money.Max(cap, 0))
+		steps = append(steps, step)
+		cap -= step.Amount
+		taxable -= step.Amount
+	}
+	return steps
+}
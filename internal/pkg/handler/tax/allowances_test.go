@@ -0,0 +1,114 @@
+package tax
+
+import (
+	"testing"
+
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowanceCap(t *testing.T) {
+	// Arrange
+	testCases := []struct {
+		year          int
+		allowanceType string
+		count         int
+		totalIncome   money.Amount
+		expected      money.Amount
+	}{
+		{2567, "donation", 0, money.New(500000), money.New(100000)},
+		{2567, "k-receipt", 0, money.New(500000), money.New(50000)},
+		{2566, "k-receipt", 0, money.New(500000), money.New(40000)},
+		{2567, "social-security", 0, money.New(500000), money.New(9000)},
+		{2567, "provident-fund", 0, money.New(500000), money.New(75000)},
+		{2567, "provident-fund", 0, money.New(5000000), money.New(500000)},
+		{2567, "ssf", 0, money.New(1000000), money.New(200000)},
+		{2566, "thai-esg", 0, money.New(2000000), money.New(100000)},
+		{2567, "thai-esg", 0, money.New(2000000), money.New(300000)},
+		{2567, "child", 0, money.New(500000), money.New(30000)},
+		{2567, "child", 3, money.New(500000), money.New(90000)},
+		{2567, "parent-care", 6, money.New(500000), money.New(120000)},
+		{2567, "political-donation", 0, money.New(500000), money.New(10000)},
+	}
+
+	for _, tc := range testCases {
+		// Act
+		actual := taxRules[tc.year].allowanceCap(tc.allowanceType, tc.count, tc.totalIncome)
+
+		// Assertions
+		assert.Equal(t, tc.expected, actual, "%d %s", tc.year, tc.allowanceType)
+	}
+}
+
+func TestTaxCalculateAllowanceGroups(t *testing.T) {
+	// Arrange
+	inputData := TaxRequestObject{TotalIncome: money.New(1000000), Allowances: []Allowance{
+		{AllowanceType: "life-insurance", Amount: money.New(90000)},
+		{AllowanceType: "health-insurance", Amount: money.New(25000)},
+		{AllowanceType: "provident-fund", Amount: money.New(200000)},
+		{AllowanceType: "rmf", Amount: money.New(300000)},
+		{AllowanceType: "ssf", Amount: money.New(200000)},
+		{AllowanceType: "child", Amount: money.New(60000), Count: 2},
+	}}
+
+	// Act
+	result := taxCalculate(inputData, taxRules[2567])
+
+	// Assertions
+	assert.Equal(t, money.New(280000), result.TaxableIncome)
+	assert.Equal(t, money.New(13000), result.Tax)
+	groups := []CalculationStep{}
+	for _, step := range result.Steps {
+		if step.Step == stepAllowanceGroup {
+			groups = append(groups, step)
+		}
+	}
+	if assert.Len(t, groups, 2) {
+		assert.Equal(t, "insurance", groups[0].Group)
+		assert.Equal(t, money.New(100000), groups[0].Amount)
+		assert.Equal(t, "retirement", groups[1].Group)
+		assert.Equal(t, money.New(500000), groups[1].Amount)
+	}
+}
+
+func TestTaxCalculateDonations(t *testing.T) {
+	// Arrange
+	statutory := taxRules[2567]
+	statutory.DonationMode = donationModeStatutory
+	testCases := []struct {
+		rule       TaxRule
+		allowances []Allowance
+		expected   map[string]money.Amount
+		taxable    money.Amount
+	}{
+		{statutory, []Allowance{
+			{AllowanceType: "life-insurance", Amount: money.New(40000)},
+			{AllowanceType: "donation", Amount: money.New(100000)},
+			{AllowanceType: "education-donation", Amount: money.New(30000)},
+		}, map[string]money.Amount{"education-donation": money.New(60000), "donation": money.New(84000)}, money.New(756000)},
+		{statutory, []Allowance{
+			{AllowanceType: "life-insurance", Amount: money.New(40000)},
+			{AllowanceType: "hospital-donation", Amount: money.New(10000)},
+			{AllowanceType: "education-donation", Amount: money.New(50000)},
+		}, map[string]money.Amount{"education-donation": money.New(90000), "hospital-donation": 0}, money.New(810000)},
+		{taxRules[2567], []Allowance{
+			{AllowanceType: "donation", Amount: money.New(80000)},
+			{AllowanceType: "education-donation", Amount: money.New(20000)},
+		}, map[string]money.Amount{"education-donation": money.New(40000), "donation": money.New(60000)}, money.New(840000)},
+	}
+
+	for _, tc := range testCases {
+		// Act
+		result := taxCalculate(TaxRequestObject{TotalIncome: money.New(1000000), Allowances: tc.allowances}, tc.rule)
+
+		// Assertions
+		actual := map[string]money.Amount{}
+		for _, step := range result.Steps {
+			if step.Step == stepAllowance && tc.rule.Allowances[step.AllowanceType].donation {
+				actual[step.AllowanceType] = step.Amount
+			}
+		}
+		assert.Equal(t, tc.expected, actual)
+		assert.Equal(t, tc.taxable, result.TaxableIncome)
+	}
+}
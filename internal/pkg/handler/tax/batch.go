@@ -0,0 +1,425 @@
+package tax
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/kidkrub/assessment-tax/internal/pkg/config"
+	"github.com/kidkrub/assessment-tax/internal/pkg/db"
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
+	"github.com/labstack/echo/v4"
+)
+
+// TaxBatchResponseObject reports the progress of a batch. Progress is the
+// percentage of rows calculated, Results the URL of the results and Summary
+// the summary of the rows once the batch is completed.
+type TaxBatchResponseObject struct {
+	ID            string          `json:"id"`
+	Status        string          `json:"status"`
+	TaxYear       int             `json:"taxYear,omitempty"`
+	TotalRows     int             `json:"totalRows"`
+	ProcessedRows int             `json:"processedRows"`
+	Succeeded     int             `json:"succeeded"`
+	Failed        int             `json:"failed"`
+	Progress      int             `json:"progress"`
+	Error         string          `json:"error,omitempty"`
+	Results       string          `json:"results,omitempty"`
+	Summary       json.RawMessage `json:"summary,omitempty"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	UpdatedAt     time.Time       `json:"updatedAt"`
+}
+
+const (
+	// batchPollInterval is how often the worker looks for a pending batch
+	// when it's idle, and renews the lease on the batch it calculates
+	batchPollInterval = time.Second
+	// batchLease is how long a batch stays claimed by a worker that stopped
+	// renewing it, before another worker may resume it
+	batchLease = 30 * time.Second
+	// batchSaveRows is the number of rows whose results are saved together
+	batchSaveRows = 100
+)
+
+func newTaxBatchResponse(batch db.TaxBatch) TaxBatchResponseObject {
+	res := TaxBatchResponseObject{
+		ID:            batch.ID,
+		Status:        batch.Status,
+		TaxYear:       batch.TaxYear,
+		TotalRows:     batch.TotalRows,
+		ProcessedRows: batch.ProcessedRows,
+		Succeeded:     batch.Succeeded,
+		Failed:        batch.Failed,
+		Error:         batch.Error,
+		CreatedAt:     batch.CreatedAt,
+		UpdatedAt:     batch.UpdatedAt,
+	}
+	if batch.TotalRows > 0 {
+		res.Progress = batch.ProcessedRows * 100 / batch.TotalRows
+	}
+	if batch.Status == db.BatchCompleted {
+		res.Results = batchURL(batch.ID) + "/results"
+		res.Summary = batch.Summary
+	}
+	return res
+}
+
+func batchURL(id string) string {
+	return "/tax/batches/" + id
+}
+
+func newBatchID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// TaxBatchCreateHandler accepts the same form as TaxUploadCalulateHandler and
+// stores the file for the worker with the snapshot of the rule sets its rows
+// are calculated with, even when the batch is resumed after a restart. The
+// file is checked up to the number of its rows before it's accepted; the rows
+// themselves are checked by the worker.
+func (h handler) TaxBatchCreateHandler(c echo.Context) error {
+	form, err := h.readUploadForm(c)
+	if err != nil {
+		return err
+	}
+	defer form.file.Close()
+	content, err := io.ReadAll(form.file)
+	if err != nil {
+		return problem.Wrap(err)
+	}
+	upload, err := h.newTaxUpload(bytes.NewReader(content), form.taxYear, form.fileOptions)
+	if err != nil {
+		return err
+	}
+	fileOptions, err := json.Marshal(form.fileOptions)
+	if err != nil {
+		return problem.Wrap(err)
+	}
+	defer upload.close()
+	for {
+		if _, _, err := upload.next(); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	if upload.rows == 0 {
+		return problem.New(invalidTaxFile, "Invalid file format. The file must have at least one row")
+	}
+
+	rules, err := h.takeTaxRuleSnapshot(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	settings, err := json.Marshal(rules.settings)
+	if err != nil {
+		return problem.Wrap(err)
+	}
+	id, err := newBatchID()
+	if err != nil {
+		return problem.Wrap(err)
+	}
+	batch, err := db.CreateTaxBatch(h.db, db.TaxBatch{ID: id, TaxYear: form.taxYear, Header: upload.columns.header, Content: content, Options: fileOptions, Rules: settings, TotalRows: upload.rows})
+	if err != nil {
+		return problem.Wrap(err)
+	}
+	c.Response().Header().Set(echo.HeaderLocation, batchURL(batch.ID))
+	return c.JSON(http.StatusAccepted, newTaxBatchResponse(batch))
+}
+
+func (h handler) TaxBatchGetHandler(c echo.Context) error {
+	batch, err := h.taxBatch(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newTaxBatchResponse(batch))
+}
+
+func (h handler) TaxBatchCancelHandler(c echo.Context) error {
+	batch, err := db.CancelTaxBatch(h.db, c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		if batch, err = h.taxBatch(c.Param("id")); err != nil {
+			return err
+		}
+		return problem.Newf(batchEnded, "the batch is already %s", batch.Status)
+	}
+	if err != nil {
+		return problem.Wrap(err)
+	}
+	return c.JSON(http.StatusOK, newTaxBatchResponse(batch))
+}
+
+// TaxBatchResultsHandler writes the results of a completed batch in the format
+// TaxUploadCalulateHandler would, chosen by the Accept header.
+func (h handler) TaxBatchResultsHandler(c echo.Context) error {
+	batch, err := h.taxBatch(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	if batch.Status != db.BatchCompleted {
+		return problem.Newf(batchNotCompleted, "the batch is %s", batch.Status)
+	}
+	options, err := readUploadOutputOptions(c, batchRules{h.db, batch.ID}, batch.Header, batch.TaxYear)
+	if err != nil {
+		return err
+	}
+	summary := TaxUploadSummary{Total: batch.TotalRows, Succeeded: batch.Succeeded, Failed: batch.Failed}
+	if batch.Summary != nil {
+		if err := json.Unmarshal(batch.Summary, &summary); err != nil {
+			return problem.Wrap(err)
+		}
+	}
+	output := newUploadOutput(c, options)
+	if err := eachBatchRow(h.db, batch.ID, output.writeRow); err != nil {
+		return output.end(TaxUploadSummary{}, problem.Wrap(err))
+	}
+	return output.end(summary, nil)
+}
+
+// batchRules gives the rule sets a batch was calculated with, read from the
+// batch when they're needed.
+type batchRules struct {
+	conn *sql.DB
+	id   string
+}
+
+func (r batchRules) taxRule(year int) (TaxRule, error) {
+	content, err := db.GetTaxBatchRules(r.conn, r.id)
+	if err != nil {
+		return TaxRule{}, problem.Wrap(err)
+	}
+	settings := ruleSettings{}
+	if err := json.Unmarshal(content, &settings); err != nil {
+		return TaxRule{}, problem.Wrap(err)
+	}
+	return newTaxRuleSnapshot(settings).taxRule(year)
+}
+
+// eachBatchRow calls fn with the result of each row of a batch, as it was
+// calculated.
+func eachBatchRow(conn *sql.DB, id string, fn func(row TaxUploadResponseObject) error) error {
+	return db.EachTaxBatchResult(conn, id, func(result []byte) error {
+		row := batchRow{}
+		if err := json.Unmarshal(result, &row); err != nil {
+			return err
+		}
+		row.record, row.taxLevels, row.effectiveRate = row.Record, row.TaxLevels, row.EffectiveRate
+		return fn(row.TaxUploadResponseObject)
+	})
+}
+
+func (h handler) taxBatch(id string) (db.TaxBatch, error) {
+	batch, err := db.GetTaxBatch(h.db, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return batch, problem.New(problem.NotFound, "no batch with this id")
+	}
+	if err != nil {
+		return batch, problem.Wrap(err)
+	}
+	return batch, nil
+}
+
+// BatchWorker calculates the pending batches in the background, one at a time.
+// Each server may run a worker: a batch is leased to the worker calculating it,
+// which renews the lease every interval while it runs.
+type BatchWorker struct {
+	h        handler
+	interval time.Duration
+	// owner identifies the worker in the leases it holds
+	owner string
+}
+
+func NewBatchWorker(db *sql.DB, taxConfig config.Tax, uploadConfig config.Upload) *BatchWorker {
+	return &BatchWorker{h: *New(db, taxConfig, uploadConfig), interval: batchPollInterval}
+}
+
+// Run calculates the pending batches until ctx is done, resuming those whose
+// lease ran out as their worker stopped.
+func (w *BatchWorker) Run(ctx context.Context) error {
+	owner, err := newBatchID()
+	if err != nil {
+		return err
+	}
+	w.owner = owner
+	for ctx.Err() == nil {
+		batch, err := db.ClaimTaxBatch(w.h.db, w.owner, batchLease)
+		if err == nil {
+			w.process(ctx, batch)
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Println("claiming a tax batch:", err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(w.interval):
+		}
+	}
+	return nil
+}
+
+// process calculates the rows of batch not calculated yet, saving the results
+// as it goes. A batch interrupted by ctx stays running to be resumed, and it
+// stops as soon as it's no longer running by the worker, e.g. cancelled.
+func (w *BatchWorker) process(ctx context.Context, batch db.TaxBatch) {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go w.renew(ctx, stop, batch.ID)
+	output := &batchOutput{ctx: ctx, db: w.h.db, id: batch.ID, owner: w.owner, index: batch.ProcessedRows}
+	fileOptions := uploadFileOptions{}
+	settings := ruleSettings{}
+	var upload *taxUpload
+	err := json.Unmarshal(batch.Options, &fileOptions)
+	if err == nil {
+		err = json.Unmarshal(batch.Rules, &settings)
+	}
+	if err == nil {
+		upload, err = w.h.newTaxUpload(bytes.NewReader(batch.Content), batch.TaxYear, fileOptions)
+	}
+	if err == nil {
+		defer upload.close()
+	}
+	for err == nil && upload.rows < batch.ProcessedRows {
+		_, _, err = upload.next()
+	}
+	if err == nil && upload.rows == batch.TotalRows {
+		err = output.end(TaxUploadSummary{}, nil)
+	} else if err == nil {
+		upload.mode = uploadModeLenient
+		err = w.h.calculateUpload(ctx, upload, newTaxRuleSnapshot(settings), output)
+	} else {
+		err = output.end(TaxUploadSummary{}, err)
+	}
+	if err != nil && ctx.Err() == nil && !errors.Is(err, db.ErrBatchNotRunning) {
+		log.Printf("calculating tax batch %s: %v", batch.ID, err)
+	}
+}
+
+// renew renews the lease on the batch every interval until ctx is done, and
+// calls stop once the lease can't be renewed.
+func (w *BatchWorker) renew(ctx context.Context, stop context.CancelFunc, id string) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := db.RenewTaxBatch(w.h.db, id, w.owner, batchLease); err != nil {
+			if !errors.Is(err, db.ErrBatchNotRunning) {
+				log.Printf("renewing tax batch %s: %v", id, err)
+			}
+			stop()
+			return
+		}
+	}
+}
+
+// batchOutput saves the results of a batch every batchSaveRows rows.
+type batchOutput struct {
+	ctx   context.Context
+	db    *sql.DB
+	id    string
+	owner string
+	// index is the index of the next row
+	index     int
+	results   []db.TaxBatchResult
+	succeeded int
+	failed    int
+}
+
+// batchRow is the result of a row as it's saved, with the cells and levels
+// the CSV output repeats and the effective rate the summary averages.
+type batchRow struct {
+	TaxUploadResponseObject
+	Record        []string   `json:"record"`
+	TaxLevels     []TaxLevel `json:"taxLevels,omitempty"`
+	EffectiveRate money.Rate `json:"effectiveRate,omitempty"`
+}
+
+func (o *batchOutput) writeRow(row TaxUploadResponseObject) error {
+	if err := o.ctx.Err(); err != nil {
+		return err
+	}
+	result, err := json.Marshal(batchRow{row, row.record, row.taxLevels, row.effectiveRate})
+	if err != nil {
+		return err
+	}
+	o.results = append(o.results, db.TaxBatchResult{Index: o.index, Result: result})
+	o.index++
+	if row.Status == rowStatusOK {
+		o.succeeded++
+	} else {
+		o.failed++
+	}
+	if len(o.results) == batchSaveRows {
+		return o.save()
+	}
+	return nil
+}
+
+func (o *batchOutput) save() error {
+	if err := o.ctx.Err(); err != nil {
+		return err
+	}
+	if len(o.results) == 0 {
+		return nil
+	}
+	if err := db.SaveTaxBatchResults(o.db, o.id, o.owner, o.results, o.succeeded, o.failed); err != nil {
+		return err
+	}
+	o.results, o.succeeded, o.failed = o.results[:0], 0, 0
+	return nil
+}
+
+// end completes the batch with the summary of every row, or fails it with the
+// error that stopped it unless it was cancelled or interrupted. The summary is
+// made from the saved results as a resumed batch only calculates the last rows.
+func (o *batchOutput) end(_ TaxUploadSummary, err error) error {
+	if err == nil {
+		err = o.save()
+	}
+	var summary []byte
+	if err == nil {
+		stats := newUploadSummary()
+		err = eachBatchRow(o.db, o.id, func(row TaxUploadResponseObject) error {
+			stats.addRow(row)
+			return nil
+		})
+		if err == nil {
+			summary, err = json.Marshal(stats.result())
+		}
+	}
+	if err == nil {
+		return db.FinishTaxBatch(o.db, o.id, o.owner, db.BatchCompleted, "", summary)
+	}
+	if o.ctx.Err() != nil || errors.Is(err, db.ErrBatchNotRunning) {
+		return err
+	}
+	var problemErr *problem.Error
+	if !errors.As(err, &problemErr) {
+		problemErr = problem.Wrap(err)
+	}
+	if finishErr := db.FinishTaxBatch(o.db, o.id, o.owner, db.BatchFailed, problemErr.Detail, nil); finishErr != nil {
+		return finishErr
+	}
+	return err
+}
+
+func (o *batchOutput) streaming() bool {
+	return true
+}
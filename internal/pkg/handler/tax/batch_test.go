@@ -0,0 +1,328 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/kidkrub/assessment-tax/internal/pkg/config"
+	"github.com/kidkrub/assessment-tax/internal/pkg/db"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+var taxBatchColumns = []string{"id", "status", "tax_year", "header", "total_rows", "processed_rows", "succeeded", "failed", "error", "summary", "created_at", "updated_at"}
+
+const (
+	getTaxBatchQuery    = "SELECT id, status, tax_year, header, total_rows, processed_rows, succeeded, failed, error, summary, created_at, updated_at FROM \"tax_batches\" WHERE id = $1;"
+	cancelTaxBatchQuery = "UPDATE \"tax_batches\" SET status = $2, updated_at = now() WHERE id = $1 AND status IN ($3, $4) RETURNING id, status, tax_year, header, total_rows, processed_rows, succeeded, failed, error, summary, created_at, updated_at;"
+	saveTaxBatchQuery   = "UPDATE \"tax_batches\" SET processed_rows = processed_rows + $2, succeeded = succeeded + $3, failed = failed + $4, updated_at = now() WHERE id = $1 AND lease_owner = $6 AND status = $5;"
+	insertResultQuery   = "INSERT INTO \"tax_batch_results\" (batch_id, row_index, result) VALUES ($1, $2, $3);"
+	finishTaxBatchQuery = "UPDATE \"tax_batches\" SET status = $2, error = $3, summary = $4, updated_at = now() WHERE id = $1 AND lease_owner = $6 AND status = $5;"
+	eachResultQuery     = "SELECT result FROM \"tax_batch_results\" WHERE batch_id = $1 ORDER BY row_index;"
+	// testRules are the rule sets expectTaxRuleSnapshot reads, as stored with a batch
+	testRules = `{"defaultYear":2569,"donationMode":"legacy","brackets":{"2566":[],"2567":[],"2568":[],"2569":[]},"deductions":{"k-receipt":50000,"personal":60000}}`
+)
+
+func newBatchContext(method string, accept string) (echo.Context, *httptest.ResponseRecorder) {
+	e := echo.New()
+	req := httptest.NewRequest(method, "/", nil)
+	req.Header.Set(echo.HeaderAccept, accept)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("abc")
+	return c, rec
+}
+
+func TestTaxBatchCreateHandler(t *testing.T) {
+	// Arrange
+	content := "id,totalIncome\nA,500000.0\nB,abc\n"
+	c, rec := newUploadContext(map[string]string{"taxYear": "2567"}, content)
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	expectTaxRuleSnapshot(mock)
+	mock.ExpectQuery("INSERT INTO \"tax_batches\" (id, status, tax_year, header, content, options, rules, total_rows) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, status, tax_year, header, total_rows, processed_rows, succeeded, failed, error, summary, created_at, updated_at;").
+		WithArgs(sqlmock.AnyArg(), "pending", 2567, "{\"id\",\"totalIncome\"}", []byte(content), []byte("{}"), []byte(testRules), 2).
+		WillReturnRows(sqlmock.NewRows(taxBatchColumns).AddRow("abc", "pending", 2567, "{id,totalIncome}", 2, 0, 0, 0, "", nil, now, now))
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.TaxBatchCreateHandler(c)) {
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+		assert.Equal(t, "/tax/batches/abc", rec.Header().Get(echo.HeaderLocation))
+		assert.JSONEq(t, `{"id":"abc","status":"pending","taxYear":2567,"totalRows":2,"processedRows":0,"succeeded":0,"failed":0,"progress":0,"createdAt":"2026-10-18T09:00:00Z","updatedAt":"2026-10-18T09:00:00Z"}`, rec.Body.String())
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestErrorTaxBatchCreateHandlerMalformedFile(t *testing.T) {
+	// Arrange
+	c, _ := newUploadContext(map[string]string{"taxYear": "2567"}, "id,totalIncome\nA,500000.0\n\"B,1\n")
+	db, _, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if err, ok := h.TaxBatchCreateHandler(c).(*problem.Error); assert.True(t, ok) {
+		assert.Equal(t, invalidTaxFile, err.Type)
+	}
+}
+
+func TestTaxBatchGetHandler(t *testing.T) {
+	// Arrange
+	c, rec := newBatchContext(http.MethodGet, "")
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(getTaxBatchQuery).WithArgs("abc").WillReturnRows(sqlmock.NewRows(taxBatchColumns).AddRow("abc", "completed", 0, "{id,totalIncome}", 3, 3, 2, 1, "", []byte(`{"total":3,"succeeded":2,"failed":1}`), now, now))
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.TaxBatchGetHandler(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"id":"abc","status":"completed","totalRows":3,"processedRows":3,"succeeded":2,"failed":1,"progress":100,"results":"/tax/batches/abc/results","summary":{"total":3,"succeeded":2,"failed":1},"createdAt":"2026-10-18T09:00:00Z","updatedAt":"2026-10-18T09:00:00Z"}`, rec.Body.String())
+	}
+}
+
+func TestErrorTaxBatchGetHandlerNotFound(t *testing.T) {
+	// Arrange
+	c, _ := newBatchContext(http.MethodGet, "")
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	mock.ExpectQuery(getTaxBatchQuery).WithArgs("abc").WillReturnError(sql.ErrNoRows)
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, problem.New(problem.NotFound, "no batch with this id"), h.TaxBatchGetHandler(c))
+}
+
+func TestTaxBatchCancelHandler(t *testing.T) {
+	// Arrange
+	c, rec := newBatchContext(http.MethodDelete, "")
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(cancelTaxBatchQuery).WithArgs("abc", "cancelled", "pending", "running").WillReturnRows(sqlmock.NewRows(taxBatchColumns).AddRow("abc", "cancelled", 0, "{id,totalIncome}", 4, 1, 1, 0, "", nil, now, now))
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.TaxBatchCancelHandler(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"id":"abc","status":"cancelled","totalRows":4,"processedRows":1,"succeeded":1,"failed":0,"progress":25,"createdAt":"2026-10-18T09:00:00Z","updatedAt":"2026-10-18T09:00:00Z"}`, rec.Body.String())
+	}
+}
+
+func TestErrorTaxBatchCancelHandlerEnded(t *testing.T) {
+	// Arrange
+	c, _ := newBatchContext(http.MethodDelete, "")
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	now := time.Now()
+	mock.ExpectQuery(cancelTaxBatchQuery).WithArgs("abc", "cancelled", "pending", "running").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(getTaxBatchQuery).WithArgs("abc").WillReturnRows(sqlmock.NewRows(taxBatchColumns).AddRow("abc", "completed", 0, "{id,totalIncome}", 3, 3, 3, 0, "", nil, now, now))
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, problem.New(batchEnded, "the batch is already completed"), h.TaxBatchCancelHandler(c))
+}
+
+func TestTaxBatchResultsHandler(t *testing.T) {
+	// Arrange
+	c, rec := newBatchContext(http.MethodGet, echo.MIMEApplicationJSON)
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	now := time.Now()
+	summary := `{"total":2,"succeeded":1,"failed":1,"totalIncome":500000.0,"tax":29000.0,"taxRefund":0.0,"averageEffectiveRate":0.058,"levels":[{"level":"0-150,000","rate":0,"taxpayers":0,"income":150000.0,"tax":0.0},{"level":"150,001-500,000","rate":0.1,"taxpayers":1,"income":290000.0,"tax":29000.0}],"taxStatistics":{"min":29000.0,"p25":29000.0,"median":29000.0,"p75":29000.0,"p90":29000.0,"p99":29000.0,"max":29000.0}}`
+	mock.ExpectQuery(getTaxBatchQuery).WithArgs("abc").WillReturnRows(sqlmock.NewRows(taxBatchColumns).AddRow("abc", "completed", 0, "{id,totalIncome}", 2, 2, 1, 1, "", []byte(summary), now, now))
+	mock.ExpectQuery(eachResultQuery).WithArgs("abc").WillReturnRows(sqlmock.NewRows([]string{"result"}).
+		AddRow([]byte(`{"line":2,"status":"ok","totalIncome":500000,"tax":29000}`)).
+		AddRow([]byte(`{"line":3,"status":"error","errors":[{"field":"totalIncome","code":"invalid","message":"totalIncome must be a number"}]}`)))
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.TaxBatchResultsHandler(c)) {
+		assert.Equal(t, http.StatusMultiStatus, rec.Code)
+		assert.JSONEq(t, `{
+			"summary":`+summary+`,
+			"taxes":[
+				{"line":2,"status":"ok","totalIncome":500000.0,"tax":29000.0},
+				{"line":3,"status":"error","errors":[{"field":"totalIncome","code":"invalid","message":"totalIncome must be a number"}]}
+			]}`, rec.Body.String())
+	}
+}
+
+func TestErrorTaxBatchResultsHandlerNotCompleted(t *testing.T) {
+	// Arrange
+	c, _ := newBatchContext(http.MethodGet, "")
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	now := time.Now()
+	mock.ExpectQuery(getTaxBatchQuery).WithArgs("abc").WillReturnRows(sqlmock.NewRows(taxBatchColumns).AddRow("abc", "running", 0, "{id,totalIncome}", 2, 1, 1, 0, "", nil, now, now))
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, problem.New(batchNotCompleted, "the batch is running"), h.TaxBatchResultsHandler(c))
+}
+
+func TestBatchWorkerProcess(t *testing.T) {
+	rowA := `{"line":2,"id":"A","status":"ok","totalIncome":500000,"tax":29000,"record":["A","500000.0"],"taxLevels":[{"level":"0-150,000","income":150000,"rate":0,"tax":0},{"level":"150,001-500,000","income":290000,"rate":0.1,"tax":29000},{"level":"500,001-1,000,000","income":0,"rate":0.15,"tax":0},{"level":"1,000,001-2,000,000","income":0,"rate":0.2,"tax":0},{"level":"2,000,001 ขึ้นไป","income":0,"rate":0.35,"tax":0}],"effectiveRate":0.058}`
+	rowB := `{"line":3,"id":"B","status":"error","errors":[{"field":"totalIncome","code":"invalid","message":"totalIncome must be a number"}],"record":["B","abc"]}`
+	rowC := `{"line":4,"id":"C","status":"ok","totalIncome":1000,"tax":0,"record":["C","1000.0"],"taxLevels":[{"level":"0-150,000","income":0,"rate":0,"tax":0},{"level":"150,001-500,000","income":0,"rate":0.1,"tax":0},{"level":"500,001-1,000,000","income":0,"rate":0.15,"tax":0},{"level":"1,000,001-2,000,000","income":0,"rate":0.2,"tax":0},{"level":"2,000,001 ขึ้นไป","income":0,"rate":0.35,"tax":0}]}`
+	summaryAB := `{"total":2,"succeeded":1,"failed":1,"totalIncome":500000,"tax":29000,"taxRefund":0,"averageEffectiveRate":0.058,"levels":[{"level":"0-150,000","rate":0,"taxpayers":0,"income":150000,"tax":0},{"level":"150,001-500,000","rate":0.1,"taxpayers":1,"income":290000,"tax":29000},{"level":"500,001-1,000,000","rate":0.15,"taxpayers":0,"income":0,"tax":0},{"level":"1,000,001-2,000,000","rate":0.2,"taxpayers":0,"income":0,"tax":0},{"level":"2,000,001 ขึ้นไป","rate":0.35,"taxpayers":0,"income":0,"tax":0}],"taxStatistics":{"min":29000,"p25":29000,"median":29000,"p75":29000,"p90":29000,"p99":29000,"max":29000}}`
+	summaryABC := `{"total":3,"succeeded":2,"failed":1,"totalIncome":501000,"tax":29000,"taxRefund":0,"averageEffectiveRate":0.029,"levels":[{"level":"0-150,000","rate":0,"taxpayers":1,"income":150000,"tax":0},{"level":"150,001-500,000","rate":0.1,"taxpayers":1,"income":290000,"tax":29000},{"level":"500,001-1,000,000","rate":0.15,"taxpayers":0,"income":0,"tax":0},{"level":"1,000,001-2,000,000","rate":0.2,"taxpayers":0,"income":0,"tax":0},{"level":"2,000,001 ขึ้นไป","rate":0.35,"taxpayers":0,"income":0,"tax":0}],"taxStatistics":{"min":0,"p25":0,"median":0,"p75":29000,"p90":29000,"p99":29000,"max":29000}}`
+	testCases := []struct {
+		batch     db.TaxBatch
+		results   [][]any
+		succeeded int
+		// stored are the results of every row, which the summary is made from
+		stored  []string
+		status  string
+		message string
+		summary string
+	}{
+		{db.TaxBatch{ID: "abc", TaxYear: 2567, Content: []byte("id,totalIncome\nA,500000.0\nB,abc\n"), Options: []byte("{}"), Rules: []byte(testRules), TotalRows: 2}, [][]any{
+			{0, []byte(rowA)},
+			{1, []byte(rowB)},
+		}, 1, []string{rowA, rowB}, "completed", "", summaryAB},
+		{db.TaxBatch{ID: "abc", TaxYear: 2567, Content: []byte("id,totalIncome\nA,500000.0\nB,abc\nC,1000.0\n"), Options: []byte("{}"), Rules: []byte(testRules), TotalRows: 3, ProcessedRows: 2}, [][]any{
+			{2, []byte(rowC)},
+		}, 1, []string{rowA, rowB, rowC}, "completed", "", summaryABC},
+		{db.TaxBatch{ID: "abc", TaxYear: 2567, Content: []byte("id,totalIncome\nA,500000.0\nB,abc\n"), Options: []byte("{}"), Rules: []byte(testRules), TotalRows: 2, ProcessedRows: 2}, nil, 0, []string{rowA, rowB}, "completed", "", summaryAB},
+		{db.TaxBatch{ID: "abc", TaxYear: 2567, Content: []byte("id,totalIncome\n\"A,500000.0\n"), Options: []byte("{}"), Rules: []byte(testRules), TotalRows: 1}, nil, 0, nil, "failed", "Invalid file format. parse error on line 2, column 13: extraneous or missing \" in quoted-field", ""},
+	}
+
+	for _, tc := range testCases {
+		// Arrange
+		database, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		assert.NoError(t, err)
+		if len(tc.results) > 0 {
+			mock.ExpectBegin()
+			mock.ExpectExec(saveTaxBatchQuery).WithArgs("abc", len(tc.results), tc.succeeded, len(tc.results)-tc.succeeded, "running", "worker").WillReturnResult(sqlmock.NewResult(0, 1))
+			for _, result := range tc.results {
+				mock.ExpectExec(insertResultQuery).WithArgs("abc", result[0], result[1]).WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			mock.ExpectCommit()
+		}
+		var summary []byte
+		if tc.stored != nil {
+			rows := sqlmock.NewRows([]string{"result"})
+			for _, result := range tc.stored {
+				rows.AddRow([]byte(result))
+			}
+			mock.ExpectQuery(eachResultQuery).WithArgs("abc").WillReturnRows(rows)
+			summary = []byte(tc.summary)
+		}
+		mock.ExpectExec(finishTaxBatchQuery).WithArgs("abc", tc.status, tc.message, summary, "running", "worker").WillReturnResult(sqlmock.NewResult(0, 1))
+		w := NewBatchWorker(database, config.Tax{DonationMode: donationModeLegacy}, testUpload)
+		w.interval, w.owner = time.Hour, "worker"
+
+		// Act
+		w.process(context.Background(), tc.batch)
+
+		// Assert
+		assert.NoError(t, mock.ExpectationsWereMet())
+	}
+}
+
+func TestBatchWorkerProcessInterrupted(t *testing.T) {
+	// Arrange
+	database, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := NewBatchWorker(database, config.Tax{DonationMode: donationModeLegacy}, testUpload)
+	w.interval, w.owner = time.Hour, "worker"
+
+	// Act
+	w.process(ctx, db.TaxBatch{ID: "abc", TaxYear: 2567, Content: []byte("totalIncome\n500000.0\n"), Options: []byte("{}"), Rules: []byte(testRules), TotalRows: 1})
+
+	// Assert
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBatchWorkerRenewLost(t *testing.T) {
+	// Arrange
+	database, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	assert.NoError(t, err)
+	mock.ExpectExec("UPDATE \"tax_batches\" SET lease_until = now() + make_interval(secs => $3) WHERE id = $1 AND lease_owner = $2 AND status = $4;").
+		WithArgs("abc", "worker", batchLease.Seconds(), "running").WillReturnResult(sqlmock.NewResult(0, 0))
+	w := NewBatchWorker(database, config.Tax{DonationMode: donationModeLegacy}, testUpload)
+	w.interval, w.owner = time.Millisecond, "worker"
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	// Act
+	w.renew(ctx, stop, "abc")
+
+	// Assert
+	assert.Error(t, ctx.Err())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBatchOutputWriteRowCancelled(t *testing.T) {
+	// Arrange
+	database, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	output := &batchOutput{ctx: ctx, db: database, id: "abc", owner: "worker"}
+
+	// Act
+	err = output.writeRow(TaxUploadResponseObject{Status: rowStatusOK})
+
+	// Assert
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, output.results)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTaxBatchResultsHandlerCSV(t *testing.T) {
+	// Arrange
+	c, rec := newBatchContext(http.MethodGet, MIMETextCSV)
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	now := time.Now()
+	mock.ExpectQuery(getTaxBatchQuery).WithArgs("abc").WillReturnRows(sqlmock.NewRows(taxBatchColumns).AddRow("abc", "completed", 0, "{id,totalIncome}", 2, 2, 1, 1, "", nil, now, now))
+	mock.ExpectQuery(eachResultQuery).WithArgs("abc").WillReturnRows(sqlmock.NewRows([]string{"result"}).
+		AddRow([]byte(`{"line":2,"id":"A","status":"ok","totalIncome":500000,"tax":29000,"record":["A","500000.0"]}`)).
+		AddRow([]byte(`{"line":3,"id":"B","status":"error","errors":[{"field":"totalIncome","code":"invalid","message":"totalIncome must be a number"}],"record":["B","abc"]}`)))
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.TaxBatchResultsHandler(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "id,totalIncome,tax,taxRefund,status,errors\n"+
+			"A,500000.0,29000.00,0.00,ok,\n"+
+			"B,abc,,,error,totalIncome must be a number\n", rec.Body.String())
+	}
+}
+
+func TestTaxBatchResultsHandlerCSVLevels(t *testing.T) {
+	// Arrange
+	c, rec := newBatchContext(http.MethodGet, MIMETextCSV)
+	c.Request().URL.RawQuery = "levels=true"
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	now := time.Now()
+	rules := `{"defaultYear":2569,"donationMode":"legacy","brackets":{"2567":[{"lowerBound":0,"upperBound":100000,"rate":0},{"lowerBound":100000,"upperBound":null,"rate":0.5}]}}`
+	mock.ExpectQuery(getTaxBatchQuery).WithArgs("abc").WillReturnRows(sqlmock.NewRows(taxBatchColumns).AddRow("abc", "completed", 2567, "{id,totalIncome}", 1, 1, 1, 0, "", nil, now, now))
+	mock.ExpectQuery("SELECT rules FROM \"tax_batches\" WHERE id = $1;").WithArgs("abc").WillReturnRows(sqlmock.NewRows([]string{"rules"}).AddRow([]byte(rules)))
+	mock.ExpectQuery(eachResultQuery).WithArgs("abc").WillReturnRows(sqlmock.NewRows([]string{"result"}).
+		AddRow([]byte(`{"line":2,"id":"A","status":"ok","totalIncome":500000,"tax":170000,"record":["A","500000.0"],"taxLevels":[{"level":"0-100,000","income":100000,"rate":0,"tax":0},{"level":"100,001 ขึ้นไป","income":340000,"rate":0.5,"tax":170000}]}`)))
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.TaxBatchResultsHandler(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "id,totalIncome,tax,taxRefund,\"0-100,000\",\"100,001 ขึ้นไป\",status,errors\n"+
+			"A,500000.0,170000.00,0.00,0.00,170000.00,ok,\n", rec.Body.String())
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
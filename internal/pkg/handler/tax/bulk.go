@@ -0,0 +1,190 @@
+package tax
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
+	"github.com/labstack/echo/v4"
+)
+
+// TaxBulkItem is one request of a bulk calculation, identified by the client
+// with ID.
+type TaxBulkItem struct {
+	ID string `json:"id"`
+	TaxRequestObject
+	// decodeErr is why the item could not be decoded, nil when it was
+	decodeErr error
+}
+
+// TaxBulkResult is the result of the item at Index of a bulk calculation. An
+// item with the status "error" carries its errors in place of the result.
+type TaxBulkResult struct {
+	Index  int                  `json:"index"`
+	ID     string               `json:"id"`
+	Status string               `json:"status"`
+	Result *TaxResponseObject   `json:"result,omitempty"`
+	Errors []problem.FieldError `json:"errors,omitempty"`
+	// totalIncome is the income of the item, for the summary
+	totalIncome money.Amount
+}
+
+type TaxBulkResponseObject struct {
+	Summary TaxUploadSummary `json:"summary"`
+	Results []TaxBulkResult  `json:"results"`
+}
+
+const codeDuplicate = "duplicate"
+
+type bulkJob struct {
+	result TaxBulkResult
+	err    error
+}
+
+// TaxBulkCalculateHandler calculates an array of requests in one call, the
+// way TaxCalculateHandler calculates each. An invalid item, even one that
+// doesn't decode, fails on its own and the response is 207 Multi-Status when
+// any item failed.
+func (h handler) TaxBulkCalculateHandler(c echo.Context) error {
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, int64(h.upload.MaxBytes))
+	raw := []json.RawMessage{}
+	if err := c.Bind(&raw); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return problem.Newf(uploadTooLarge, "the request must not be larger than %d bytes", h.upload.MaxBytes)
+		}
+		return problem.Bind(err)
+	}
+	if len(raw) == 0 {
+		return problem.New(problem.BadRequest, "the request must have at least one item")
+	}
+	if maxItems := h.upload.MaxRows; len(raw) > maxItems {
+		return problem.Newf(uploadTooLarge, "the request must not have more than %d items", maxItems)
+	}
+	items := make([]TaxBulkItem, len(raw))
+	for i := range raw {
+		items[i] = decodeBulkItem(raw[i])
+	}
+
+	duplicates := make([]bool, len(items))
+	ids := map[string]bool{}
+	for i, item := range items {
+		duplicates[i] = ids[item.ID]
+		ids[item.ID] = true
+	}
+
+	res := TaxBulkResponseObject{Results: []TaxBulkResult{}}
+	rules, err := h.takeTaxRuleSnapshot(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	stats := newUploadSummary()
+	explain := c.QueryParam("explain") == "true"
+	read := 0
+	next := func() (int, error) {
+		if read == len(items) {
+			return 0, io.EOF
+		}
+		read++
+		return read - 1, nil
+	}
+	calculate := func(i int) bulkJob {
+		result, err := rules.bulkResult(i, items[i], duplicates[i], explain)
+		return bulkJob{result, err}
+	}
+	write := func(job bulkJob) error {
+		if job.err != nil {
+			return job.err
+		}
+		if result := job.result.Result; result != nil {
+			stats.add(job.result.totalIncome, result.Tax, result.TaxRefund, result.EffectiveRate, result.TaxLevels)
+		} else {
+			stats.addFailed()
+		}
+		res.Results = append(res.Results, job.result)
+		return nil
+	}
+	if err := inOrder(c.Request().Context(), h.upload.Workers, next, calculate, write); err != nil {
+		return err
+	}
+	res.Summary = stats.result()
+	if res.Summary.Failed > 0 {
+		return c.JSON(http.StatusMultiStatus, res)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// bulkResult calculates the item at index i of a bulk calculation. The error is
+// only for failures other than an invalid item, which are reported on the item.
+func (s *taxRuleSnapshot) bulkResult(i int, item TaxBulkItem, duplicate bool, explain bool) (TaxBulkResult, error) {
+	result := TaxBulkResult{Index: i, ID: item.ID, Status: rowStatusOK}
+	errs := []problem.FieldError{}
+	if item.ID == "" {
+		errs = append(errs, problem.NewFieldError("id", codeRequired, "id is required"))
+	} else if duplicate {
+		errs = append(errs, problem.NewFieldError("id", codeDuplicate, "id must be unique within the request"))
+	}
+	if item.decodeErr != nil {
+		errs = append(errs, decodeFieldError(item.decodeErr))
+	} else if _, err := findTaxRule(s.taxYear(item.TaxYear)); err != nil {
+		errs = append(errs, problem.NewFieldError("taxYear", codeUnsupported, err.Error()))
+	} else {
+		rule, err := s.taxRule(item.TaxYear)
+		if err != nil {
+			return result, err
+		}
+		if errs = append(errs, rule.validateTaxRequest(item.TaxRequestObject)...); len(errs) == 0 {
+			calculation := taxCalculate(item.TaxRequestObject, rule)
+			response := newTaxResponse(calculation)
+			if explain {
+				response.Steps = calculation.Steps
+			}
+			result.Result, result.totalIncome = &response, calculation.TotalIncome
+		}
+	}
+	if len(errs) > 0 {
+		result.Status, result.Errors = rowStatusError, errs
+	}
+	return result, nil
+}
+
+// decodeBulkItem decodes an item of a bulk calculation, which must not have
+// fields a request doesn't have. When the item doesn't decode, its id is still
+// kept when it has one.
+func decodeBulkItem(raw json.RawMessage) TaxBulkItem {
+	item := TaxBulkItem{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&item); err != nil {
+		id := struct {
+			ID string `json:"id"`
+		}{}
+		json.Unmarshal(raw, &id)
+		return TaxBulkItem{ID: id.ID, decodeErr: err}
+	}
+	return item
+}
+
+// decodeFieldError reports an item that doesn't decode, on the field that
+// failed when known.
+func decodeFieldError(err error) problem.FieldError {
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		field, _ = strconv.Unquote(field)
+		return problem.NewFieldError(field, codeUnknown, fmt.Sprintf("unknown field '%s'", field))
+	}
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		return problem.NewFieldError("", codeInvalid, "the item is not a valid request: "+err.Error())
+	}
+	if typeErr.Field == "" {
+		return problem.NewFieldError("", codeInvalid, "the item must be an object, got "+typeErr.Value)
+	}
+	return problem.NewFieldError(typeErr.Field, codeInvalid, fmt.Sprintf("%s has the wrong type, got %s", typeErr.Field, typeErr.Value))
+}
@@ -0,0 +1,127 @@
+package tax
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/kidkrub/assessment-tax/internal/pkg/config"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func newBulkContext(reqBody string) (echo.Context, *httptest.ResponseRecorder) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(reqBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	return e.NewContext(req, rec), rec
+}
+
+func TestTaxBulkCalculateHandler(t *testing.T) {
+	// Arrange
+	c, rec := newBulkContext(`[
+		{"id":"A","taxYear":2567,"totalIncome":500000.0,"wht":0.0,"allowances":[]},
+		{"id":"B","taxYear":2567,"totalIncome":500000.0,"wht":30000.0,"allowances":[{"allowanceType":"donation","amount":100000.0}]}
+	]`)
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	expectTaxRuleSnapshot(mock)
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.TaxBulkCalculateHandler(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{
+			"summary":{"total":2,"succeeded":2,"failed":0,"totalIncome":1000000,"tax":29000,"taxRefund":11000,"averageEffectiveRate":0.048,"levels":[{"level":"0-150,000","rate":0,"taxpayers":0,"income":300000,"tax":0},{"level":"150,001-500,000","rate":0.1,"taxpayers":2,"income":480000,"tax":48000},{"level":"500,001-1,000,000","rate":0.15,"taxpayers":0,"income":0,"tax":0},{"level":"1,000,001-2,000,000","rate":0.2,"taxpayers":0,"income":0,"tax":0},{"level":"2,000,001 ขึ้นไป","rate":0.35,"taxpayers":0,"income":0,"tax":0}],"taxStatistics":{"min":0,"p25":0,"median":0,"p75":29000,"p90":29000,"p99":29000,"max":29000}},
+			"results":[
+				{"index":0,"id":"A","status":"ok","result":{"tax":29000.0,"taxableIncome":440000.0,"netIncome":471000.0,"effectiveRate":0.058,"marginalRate":0.1,"marginalLevel":"150,001-500,000","taxMethod":"progressive","progressiveTax":29000.0,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000.0,"rate":0,"tax":0.0},{"level":"150,001-500,000","income":290000.0,"rate":0.1,"tax":29000.0},{"level":"500,001-1,000,000","income":0.0,"rate":0.15,"tax":0.0},{"level":"1,000,001-2,000,000","income":0.0,"rate":0.2,"tax":0.0},{"level":"2,000,001 ขึ้นไป","income":0.0,"rate":0.35,"tax":0.0}]}},
+				{"index":1,"id":"B","status":"ok","result":{"tax":0.0,"taxRefund":11000.0,"taxableIncome":340000.0,"netIncome":481000.0,"effectiveRate":0.038,"marginalRate":0.1,"marginalLevel":"150,001-500,000","taxMethod":"progressive","progressiveTax":19000.0,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000.0,"rate":0,"tax":0.0},{"level":"150,001-500,000","income":190000.0,"rate":0.1,"tax":19000.0},{"level":"500,001-1,000,000","income":0.0,"rate":0.15,"tax":0.0},{"level":"1,000,001-2,000,000","income":0.0,"rate":0.2,"tax":0.0},{"level":"2,000,001 ขึ้นไป","income":0.0,"rate":0.35,"tax":0.0}]}}
+			]}`, rec.Body.String())
+	}
+}
+
+func TestTaxBulkCalculateHandlerItemErrors(t *testing.T) {
+	// Arrange
+	c, rec := newBulkContext(`[
+		{"id":"A","taxYear":2567,"totalIncome":-1.0,"wht":0.0,"allowances":[]},
+		{"id":"A","taxYear":2567,"totalIncome":100000.0,"wht":0.0,"allowances":[{"allowanceType":"rent","amount":1.0}]},
+		{"taxYear":2500,"totalIncome":100000.0,"wht":0.0,"allowances":[]},
+		{"id":"B","taxYear":2567,"totalIncome":100000.0,"wht":0.0,"allowances":[]}
+	]`)
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	expectTaxRuleSnapshot(mock)
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.TaxBulkCalculateHandler(c)) {
+		assert.Equal(t, http.StatusMultiStatus, rec.Code)
+		assert.JSONEq(t, `{
+			"summary":{"total":4,"succeeded":1,"failed":3,"totalIncome":100000,"tax":0,"taxRefund":0,"averageEffectiveRate":0,"levels":[{"level":"0-150,000","rate":0,"taxpayers":1,"income":40000,"tax":0},{"level":"150,001-500,000","rate":0.1,"taxpayers":0,"income":0,"tax":0},{"level":"500,001-1,000,000","rate":0.15,"taxpayers":0,"income":0,"tax":0},{"level":"1,000,001-2,000,000","rate":0.2,"taxpayers":0,"income":0,"tax":0},{"level":"2,000,001 ขึ้นไป","rate":0.35,"taxpayers":0,"income":0,"tax":0}],"taxStatistics":{"min":0,"p25":0,"median":0,"p75":0,"p90":0,"p99":0,"max":0}},
+			"results":[
+				{"index":0,"id":"A","status":"error","errors":[{"field":"totalIncome","code":"negative","message":"totalIncome must not be negative"}]},
+				{"index":1,"id":"A","status":"error","errors":[{"field":"id","code":"duplicate","message":"id must be unique within the request"},{"field":"allowances[0].allowanceType","code":"unknown","message":"unknown allowanceType 'rent'"}]},
+				{"index":2,"id":"","status":"error","errors":[{"field":"id","code":"required","message":"id is required"},{"field":"taxYear","code":"unsupported","message":"unsupported tax year 2500"}]},
+				{"index":3,"id":"B","status":"ok","result":{"tax":0.0,"taxableIncome":40000.0,"netIncome":100000.0,"effectiveRate":0,"marginalRate":0,"marginalLevel":"0-150,000","taxMethod":"progressive","progressiveTax":0.0,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":40000.0,"rate":0,"tax":0.0},{"level":"150,001-500,000","income":0.0,"rate":0.1,"tax":0.0},{"level":"500,001-1,000,000","income":0.0,"rate":0.15,"tax":0.0},{"level":"1,000,001-2,000,000","income":0.0,"rate":0.2,"tax":0.0},{"level":"2,000,001 ขึ้นไป","income":0.0,"rate":0.35,"tax":0.0}]}}
+			]}`, rec.Body.String())
+	}
+}
+
+func TestTaxBulkCalculateHandlerDecodeErrors(t *testing.T) {
+	// Arrange
+	c, rec := newBulkContext(`[
+		{"id":"A","taxYear":2567,"totalIncome":"NaN","wht":0.0,"allowances":[]},
+		{"id":"B","taxYear":2567,"totalIncome":100000.0,"wht":0.0,"allowances":{}},
+		1,
+		{"id":"D","taxYear":2567,"totalIncome":100000.0,"wht":0.0,"bonus":1.0},
+		{"id":"C","taxYear":2567,"totalIncome":100000.0,"wht":0.0,"allowances":[]}
+	]`)
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	expectTaxRuleSnapshot(mock)
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.TaxBulkCalculateHandler(c)) {
+		assert.Equal(t, http.StatusMultiStatus, rec.Code)
+		assert.JSONEq(t, `{
+			"summary":{"total":5,"succeeded":1,"failed":4,"totalIncome":100000,"tax":0,"taxRefund":0,"averageEffectiveRate":0,"levels":[{"level":"0-150,000","rate":0,"taxpayers":1,"income":40000,"tax":0},{"level":"150,001-500,000","rate":0.1,"taxpayers":0,"income":0,"tax":0},{"level":"500,001-1,000,000","rate":0.15,"taxpayers":0,"income":0,"tax":0},{"level":"1,000,001-2,000,000","rate":0.2,"taxpayers":0,"income":0,"tax":0},{"level":"2,000,001 ขึ้นไป","rate":0.35,"taxpayers":0,"income":0,"tax":0}],"taxStatistics":{"min":0,"p25":0,"median":0,"p75":0,"p90":0,"p99":0,"max":0}},
+			"results":[
+				{"index":0,"id":"A","status":"error","errors":[{"field":"","code":"invalid","message":"the item is not a valid request: invalid decimal \"NaN\""}]},
+				{"index":1,"id":"B","status":"error","errors":[{"field":"allowances","code":"invalid","message":"allowances has the wrong type, got object"}]},
+				{"index":2,"id":"","status":"error","errors":[{"field":"id","code":"required","message":"id is required"},{"field":"","code":"invalid","message":"the item must be an object, got number"}]},
+				{"index":3,"id":"D","status":"error","errors":[{"field":"bonus","code":"unknown","message":"unknown field 'bonus'"}]},
+				{"index":4,"id":"C","status":"ok","result":{"tax":0.0,"taxableIncome":40000.0,"netIncome":100000.0,"effectiveRate":0,"marginalRate":0,"marginalLevel":"0-150,000","taxMethod":"progressive","progressiveTax":0.0,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":40000.0,"rate":0,"tax":0.0},{"level":"150,001-500,000","income":0.0,"rate":0.1,"tax":0.0},{"level":"500,001-1,000,000","income":0.0,"rate":0.15,"tax":0.0},{"level":"1,000,001-2,000,000","income":0.0,"rate":0.2,"tax":0.0},{"level":"2,000,001 ขึ้นไป","income":0.0,"rate":0.35,"tax":0.0}]}}
+			]}`, rec.Body.String())
+	}
+}
+
+func TestErrorTaxBulkCalculateHandler(t *testing.T) {
+	testCases := []struct {
+		reqBody string
+		limits  config.Upload
+		status  int
+	}{
+		{`[]`, testUpload, http.StatusBadRequest},
+		{`{"id":"A"}`, testUpload, http.StatusBadRequest},
+		{`[{"id":"A"},{"id":"B"},{"id":"C"}]`, config.Upload{MaxRows: 2, MaxBytes: testUpload.MaxBytes, Workers: testUpload.Workers}, http.StatusRequestEntityTooLarge},
+		{`[{"id":"A","totalIncome":500000.0}]`, config.Upload{MaxRows: testUpload.MaxRows, MaxBytes: 16, Workers: testUpload.Workers}, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range testCases {
+		c, _ := newBulkContext(tc.reqBody)
+		db, _, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		h := newTestHandler(db)
+		h.upload = tc.limits
+
+		err := h.TaxBulkCalculateHandler(c)
+
+		if assert.Error(t, err, tc.reqBody) {
+			assert.Equal(t, tc.status, err.(*problem.Error).Type.Status, tc.reqBody)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package tax
+
+import "github.com/kidkrub/assessment-tax/internal/pkg/money"
+
+// CalculationStep is one entry of the audit trail returned with explain=true.
+// Only the fields relevant to the step are set.
+type CalculationStep struct {
+	Step          string        `json:"step"`
+	Category      string        `json:"category,omitempty"`
+	AllowanceType string        `json:"allowanceType,omitempty"`
+	Group         string        `json:"group,omitempty"`
+	Level         string        `json:"level,omitempty"`
+	Requested     *money.Amount `json:"requested,omitempty"`
+	Cap           *money.Amount `json:"cap,omitempty"`
+	Base          *money.Amount `json:"base,omitempty"`
+	Rate          *money.Rate   `json:"rate,omitempty"`
+	Amount        money.Amount  `json:"amount"`
+}
+
+const (
+	stepTotalIncome       = "totalIncome"
+	stepExpenseDeduction  = "expenseDeduction"
+	stepPersonalDeduction = "personalDeduction"
+	stepAllowance         = "allowance"
+	stepAllowanceGroup    = "allowanceGroup"
+	stepTaxableIncome     = "taxableIncome"
+	stepTaxLevel          = "taxLevel"
+	stepMinimumTax        = "minimumTax"
+	stepGrossTax          = "grossTax"
+	stepWht               = "wht"
+	stepTax               = "tax"
+	stepTaxRefund         = "taxRefund"
+)
+
+const (
+	taxMethodProgressive = "progressive"
+	taxMethodMinimum     = "minimum"
+)
+
+type taxResult struct {
+	TotalIncome   money.Amount
+	Incomes       []IncomeBreakdown
+	TaxableIncome money.Amount
+	// ProgressiveTax is the tax by the brackets, MinimumTax is the tax on
+	// income other than salary, 0 when under the threshold.
+	ProgressiveTax money.Amount
+	MinimumTax     money.Amount
+	TaxMethod      string
+	// GrossTax is the higher of the two methods, before the WHT credit.
+	GrossTax money.Amount
+	// Tax is the tax still to pay after WHT, negative for a refund.
+	Tax       money.Amount
+	TaxLevels []TaxLevel
+	Steps     []CalculationStep
+}
+
+// levelsTax sums the tax of every level before the satang are dropped.
+func (r taxResult) levelsTax() money.Amount {
+	tax := money.Amount(0)
+	for _, level := range r.TaxLevels {
+		tax += level.Tax
+	}
+	return tax
+}
+
+// assessedTax is the tax the method applied comes to before the satang of the
+// brackets are dropped.
+func (r taxResult) assessedTax() money.Amount {
+	return money.Max(r.levelsTax(), r.MinimumTax)
+}
+
+// nonSalaryIncome sums the income other than 40(1), which the minimum tax is
+// assessed on.
+func (r taxResult) nonSalaryIncome() money.Amount {
+	income := money.Amount(0)
+	for _, breakdown := range r.Incomes {
+		if breakdown.Category != "40(1)" {
+			income += breakdown.Income
+		}
+	}
+	return income
+}
+
+// marginalLevel returns the level the last baht of taxable income falls in,
+// which is the first level when nothing is taxable.
+func (r taxResult) marginalLevel() *TaxLevel {
+	return marginalLevel(r.TaxLevels)
+}
+
+// marginalLevel returns the marginal level of levels, as taxResult.marginalLevel
+// does.
+func marginalLevel(levels []TaxLevel) *TaxLevel {
+	if len(levels) == 0 {
+		return nil
+	}
+	marginal := &levels[0]
+	for i := range levels {
+		if levels[i].Income > 0 {
+			marginal = &levels[i]
+		}
+	}
+	return marginal
+}
+
+// taxCalculate assesses the request against the rule set, recording every step
+// it takes. Each tier is rounded to the satang and the satang of the total tax
+// are dropped.
+func taxCalculate(inputData TaxRequestObject, rule TaxRule) (result taxResult) {
+	result.TotalIncome = inputData.TotalIncome
+	taxable := inputData.TotalIncome
+	if len(inputData.Incomes) > 0 {
+		result.Incomes = deductExpenses(inputData.Incomes)
+		result.TotalIncome, taxable = 0, 0
+		for _, income := range result.Incomes {
+			result.TotalIncome += income.Income
+			taxable += income.NetIncome
+		}
+	}
+	result.Steps = append(result.Steps, CalculationStep{Step: stepTotalIncome, Amount: result.TotalIncome})
+	for _, income := range result.Incomes {
+		result.Steps = append(result.Steps, CalculationStep{Step: stepExpenseDeduction, Category: income.Category, Base: &income.Income, Amount: income.ExpenseDeduction})
+	}
+
+	personalDeduct := rule.Deductions["personal"]
+	result.Steps = append(result.Steps, CalculationStep{Step: stepPersonalDeduction, Amount: personalDeduct})
+	taxable -= personalDeduct
+
+	if len(inputData.Allowances) > 0 {
+		filers := filerAllowances(inputData.Allowances)
+		deductDonations := func(requested map[string]money.Amount) {
+			for _, step := range rule.donationSteps(requested, taxable) {
+				result.Steps = append(result.Steps, step)
+				taxable -= step.Amount
+			}
+		}
+		if rule.DonationMode != donationModeStatutory {
+			for _, filer := range filers {
+				deductDonations(filer.requested)
+			}
+		}
+		for i, filer := range filers {
+			totalIncome := result.TotalIncome
+			if i < len(inputData.filerIncomes) {
+				totalIncome = inputData.filerIncomes[i]
+			}
+			grouped := map[string]money.Amount{}
+			for _, allowanceType := range allowanceTypes {
+				amount, ok := filer.requested[allowanceType]
+				if !ok || rule.Allowances[allowanceType].donation {
+					continue
+				}
+				step := allowanceStep(allowanceType, amount, rule.allowanceCap(allowanceType, filer.counts[allowanceType], totalIncome))
+				result.Steps = append(result.Steps, step)
+				if group := rule.Allowances[allowanceType].group; group != "" {
+					grouped[group] += step.Amount
+					continue
+				}
+				taxable -= step.Amount
+			}
+			for _, group := range allowanceGroups {
+				amount, ok := grouped[group]
+				if !ok {
+					continue
+				}
+				step := allowanceStep("", amount, rule.GroupCaps[group])
+				step.Step = stepAllowanceGroup
+				step.Group = group
+				result.Steps = append(result.Steps, step)
+				taxable -= step.Amount
+			}
+		}
+		if rule.DonationMode == donationModeStatutory {
+			requested := map[string]money.Amount{}
+			for _, filer := range filers {
+				for allowanceType, amount := range filer.requested {
+					requested[allowanceType] += amount
+				}
+			}
+			deductDonations(requested)
+		}
+	}
+	result.TaxableIncome = money.Max(taxable, 0)
+	result.Steps = append(result.Steps, CalculationStep{Step: stepTaxableIncome, Amount: result.TaxableIncome})
+
+	tax := money.Amount(0)
+	for _, taxLevel := range rule.Levels {
+		base := money.Max(taxable, 0)
+		if taxLevel.tierDiff != -1 {
+			base = money.Min(base, taxLevel.tierDiff)
+		}
+		tierTax := base.Mul(taxLevel.multiplier)
+		tax += tierTax
+		taxable -= base
+		result.TaxLevels = append(result.TaxLevels, TaxLevel{taxLevel.level, base, taxLevel.multiplier, tierTax})
+		result.Steps = append(result.Steps, CalculationStep{Step: stepTaxLevel, Level: taxLevel.level, Base: &base, Rate: &taxLevel.multiplier, Amount: tierTax})
+	}
+	result.ProgressiveTax = tax.Truncate()
+	result.TaxMethod = taxMethodProgressive
+	tax = result.ProgressiveTax
+	if nonSalary := result.nonSalaryIncome(); nonSalary > rule.MinimumTaxThreshold {
+		result.MinimumTax = nonSalary.Mul(rule.MinimumTaxRate)
+		result.Steps = append(result.Steps, CalculationStep{Step: stepMinimumTax, Base: &nonSalary, Rate: &rule.MinimumTaxRate, Amount: result.MinimumTax})
+		result.MinimumTax = result.MinimumTax.Truncate()
+		if result.MinimumTax > tax {
+			result.TaxMethod = taxMethodMinimum
+			tax = result.MinimumTax
+		}
+	}
+	result.GrossTax = tax
+	result.Tax = tax - inputData.Wht
+	result.Steps = append(result.Steps,
+		CalculationStep{Step: stepGrossTax, Amount: tax},
+		CalculationStep{Step: stepWht, Amount: inputData.Wht},
+	)
+	if result.Tax < 0 {
+		result.Steps = append(result.Steps, CalculationStep{Step: stepTaxRefund, Amount: -result.Tax})
+	} else {
+		result.Steps = append(result.Steps, CalculationStep{Step: stepTax, Amount: result.Tax})
+	}
+	return result
+}
+
+type filerAllowance struct {
+	requested map[string]money.Amount
+	counts    map[string]int
+}
+
+// filerAllowances sums the allowances of each filer by type, filer by filer.
+func filerAllowances(allowances []Allowance) []filerAllowance {
+	lastFiler := 0
+	for _, allowance := range allowances {
+		lastFiler = max(lastFiler, allowance.filer)
+	}
+	filers := make([]filerAllowance, lastFiler+1)
+	for i := range filers {
+		filers[i] = filerAllowance{map[string]money.Amount{}, map[string]int{}}
+	}
+	for _, allowance := range allowances {
+		filers[allowance.filer].requested[allowance.AllowanceType] += allowance.Amount
+		filers[allowance.filer].counts[allowance.AllowanceType] += allowance.Count
+	}
+	return filers
+}
+
+func allowanceStep(allowanceType string, requested, cap money.Amount) CalculationStep {
+	return CalculationStep{Step: stepAllowance, AllowanceType: allowanceType, Requested: &requested, Cap: &cap, Amount: money.Min(requested, cap)}
+}
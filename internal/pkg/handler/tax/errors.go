@@ -0,0 +1,16 @@
+package tax
+
+import (
+	"net/http"
+
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
+)
+
+var (
+	unsupportedTaxYear = problem.NewType("unsupported-tax-year", "Unsupported tax year", http.StatusBadRequest)
+	targetUnreachable  = problem.NewType("target-unreachable", "Target unreachable", http.StatusUnprocessableEntity)
+	invalidTaxFile     = problem.NewType("invalid-tax-file", "Invalid tax file", http.StatusBadRequest)
+	uploadTooLarge     = problem.NewType("upload-too-large", "Upload too large", http.StatusRequestEntityTooLarge)
+	batchEnded         = problem.NewType("batch-ended", "Batch ended", http.StatusConflict)
+	batchNotCompleted  = problem.NewType("batch-not-completed", "Batch not completed", http.StatusConflict)
+)
@@ -0,0 +1,255 @@
+package tax
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Encodings of an uploaded CSV file. Windows-874 extends TIS-620, so a TIS-620
+// file is read as Windows-874.
+const (
+	encodingAuto       = "auto"
+	encodingUTF8       = "utf-8"
+	encodingTIS620     = "tis-620"
+	encodingWindows874 = "windows-874"
+)
+
+// uploadFileOptions tell how to read an uploaded file. Sheet is the sheet of
+// a workbook to read, the first sheet when empty. Delimiter and Encoding are
+// those of a CSV file, detected from its content when empty.
+type uploadFileOptions struct {
+	Sheet     string `json:"sheet,omitempty"`
+	Delimiter string `json:"delimiter,omitempty"`
+	Encoding  string `json:"encoding,omitempty"`
+}
+
+// newUploadFileOptions checks the options of an upload as given in its form.
+// The delimiter "tab" stands for a tab.
+func newUploadFileOptions(sheet, delimiter, encoding string) (uploadFileOptions, error) {
+	options := uploadFileOptions{Sheet: sheet, Delimiter: delimiter, Encoding: strings.ToLower(encoding)}
+	if options.Delimiter == "tab" {
+		options.Delimiter = "\t"
+	}
+	if options.Delimiter != "" {
+		r, size := utf8.DecodeRuneInString(options.Delimiter)
+		if size != len(options.Delimiter) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+			return options, errors.New("delimiter must be a single character other than a quote or a line break")
+		}
+	}
+	switch options.Encoding {
+	case "", encodingAuto:
+		options.Encoding = ""
+	case encodingUTF8, encodingTIS620, encodingWindows874:
+	default:
+		return options, fmt.Errorf("encoding must be one of %s", strings.Join([]string{encodingAuto, encodingUTF8, encodingTIS620, encodingWindows874}, ", "))
+	}
+	return options, nil
+}
+
+// rowReader reads the rows of an uploaded file. Read returns the cells of the
+// next row with the line, or the row of a sheet, it starts on and io.EOF after
+// the last row.
+type rowReader interface {
+	Read() (record []string, line int, err error)
+	Close() error
+}
+
+// xlsxMagic starts every XLSX workbook, as it's a zip archive.
+var xlsxMagic = []byte("PK\x03\x04")
+
+// sniffSize is how much of a file is looked at to tell its format, encoding
+// and delimiter.
+const sniffSize = 64 << 10
+
+// delimiters are the delimiters a CSV file is detected with, the first one
+// being the default.
+var delimiters = []rune{',', ';', '\t', '|'}
+
+// xlsxUnzipRatio is how many times the largest upload a workbook may unzip to,
+// well above what the XML of a worksheet compresses by.
+const xlsxUnzipRatio = 20
+
+// newRowReader reads src as a workbook or as CSV depending on its content. A
+// file with a NUL byte near its start is taken as binary, neither CSV nor a
+// workbook. A CSV file that isn't UTF-8 is read as Windows-874, and a UTF-8 BOM
+// is skipped. maxBytes is the largest upload, which limits what a workbook
+// unzips to.
+func newRowReader(src io.Reader, options uploadFileOptions, maxBytes int) (rowReader, error) {
+	buffered := bufio.NewReaderSize(src, sniffSize)
+	head, err := buffered.Peek(sniffSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.HasPrefix(head, xlsxMagic) {
+		return newXLSXRowReader(buffered, options, xlsxUnzipRatio*int64(maxBytes))
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return nil, errors.New("the file must be a CSV file or an XLSX workbook")
+	}
+
+	var text io.Reader = buffered
+	encoding := options.Encoding
+	if encoding == "" {
+		encoding = encodingUTF8
+		if !validUTF8(head, err == nil) {
+			encoding = encodingWindows874
+		}
+	}
+	if encoding == encodingUTF8 && bytes.HasPrefix(head, []byte(utf8BOM)) {
+		buffered.Discard(len(utf8BOM))
+		head = head[len(utf8BOM):]
+	}
+	if encoding != encodingUTF8 {
+		decoder := charmap.Windows874.NewDecoder()
+		text = decoder.Reader(buffered)
+		if head, err = decoder.Bytes(head); err != nil {
+			return nil, err
+		}
+	}
+
+	reader := csv.NewReader(text)
+	reader.Comma = sniffDelimiter(head)
+	if options.Delimiter != "" {
+		reader.Comma, _ = utf8.DecodeRuneInString(options.Delimiter)
+	}
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	return csvRowReader{reader}, nil
+}
+
+// validUTF8 tells whether head is UTF-8. When head is only the start of the
+// file, its last rune may be cut short.
+func validUTF8(head []byte, truncated bool) bool {
+	for i := 1; truncated && i < utf8.UTFMax && len(head) > 0 && !utf8.Valid(head); i++ {
+		head = head[:len(head)-1]
+	}
+	return utf8.Valid(head)
+}
+
+// sniffDelimiter returns the delimiter found the most outside quotes on the
+// first line of head.
+func sniffDelimiter(head []byte) rune {
+	counts := map[rune]int{}
+	quoted := false
+	for _, r := range string(head) {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == '\n' && !quoted {
+			break
+		} else if !quoted {
+			counts[r]++
+		}
+	}
+	delimiter := delimiters[0]
+	for _, d := range delimiters {
+		if counts[d] > counts[delimiter] {
+			delimiter = d
+		}
+	}
+	return delimiter
+}
+
+type csvRowReader struct {
+	reader *csv.Reader
+}
+
+func (r csvRowReader) Read() ([]string, int, error) {
+	record, err := r.reader.Read()
+	if err != nil {
+		return nil, 0, err
+	}
+	line, _ := r.reader.FieldPos(0)
+	return record, line, nil
+}
+
+func (r csvRowReader) Close() error {
+	return nil
+}
+
+// xlsxHeaderRows is how many rows of a sheet are searched for the header, so
+// a sheet may start with a title.
+const xlsxHeaderRows = 20
+
+// xlsxRowReader reads the rows of a sheet from its header on. Cells are read
+// as their raw value so amounts don't depend on how they're displayed, e.g.
+// with a thousands separator or as a currency. Blank rows are skipped and the
+// rows are padded to the header, as a sheet leaves out the trailing empty
+// cells.
+type xlsxRowReader struct {
+	file  *excelize.File
+	rows  *excelize.Rows
+	line  int
+	width int
+}
+
+// newXLSXRowReader opens the workbook in src, which must not unzip to more
+// than unzipLimit bytes. Worksheets larger than the default chunk of excelize,
+// or than unzipLimit, are unzipped to temporary files rather than memory.
+func newXLSXRowReader(src io.Reader, options uploadFileOptions, unzipLimit int64) (*xlsxRowReader, error) {
+	file, err := excelize.OpenReader(src, excelize.Options{
+		RawCellValue:      true,
+		UnzipSizeLimit:    unzipLimit,
+		UnzipXMLSizeLimit: min(unzipLimit, excelize.StreamChunkSize),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("the workbook can't be read: %v", err)
+	}
+	sheet := options.Sheet
+	if sheet == "" {
+		sheet = file.GetSheetName(0)
+	}
+	rows, err := file.Rows(sheet)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("the workbook has no sheet '%s', its sheets are %s", sheet, strings.Join(file.GetSheetList(), ", "))
+	}
+	return &xlsxRowReader{file: file, rows: rows}, nil
+}
+
+func (r *xlsxRowReader) Read() ([]string, int, error) {
+	for r.rows.Next() {
+		r.line++
+		record, err := r.rows.Columns(excelize.Options{RawCellValue: true})
+		if err != nil {
+			return nil, 0, err
+		}
+		if !slices.ContainsFunc(record, func(cell string) bool { return strings.TrimSpace(cell) != "" }) {
+			continue
+		}
+		if r.width == 0 {
+			if !slices.ContainsFunc(record, func(cell string) bool { return strings.TrimSpace(cell) == columnTotalIncome }) {
+				if r.line >= xlsxHeaderRows {
+					break
+				}
+				continue
+			}
+			r.width = len(record)
+		}
+		for len(record) < r.width {
+			record = append(record, "")
+		}
+		return record, r.line, nil
+	}
+	if err := r.rows.Error(); err != nil {
+		return nil, 0, err
+	}
+	if r.width == 0 {
+		return nil, 0, fmt.Errorf("no header row with '%s' in the first %d rows of the sheet", columnTotalIncome, xlsxHeaderRows)
+	}
+	return nil, 0, io.EOF
+}
+
+func (r *xlsxRowReader) Close() error {
+	r.rows.Close()
+	return r.file.Close()
+}
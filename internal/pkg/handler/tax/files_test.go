@@ -0,0 +1,134 @@
+package tax
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// newWorkbook returns the content of a workbook with the sheets of names, in
+// order, each filled with its rows in sheets.
+func newWorkbook(t *testing.T, names []string, sheets map[string][][]interface{}) string {
+	t.Helper()
+	f := excelize.NewFile()
+	defer f.Close()
+	for i, name := range names {
+		if i == 0 {
+			assert.NoError(t, f.SetSheetName(f.GetSheetName(0), name))
+		} else {
+			_, err := f.NewSheet(name)
+			assert.NoError(t, err)
+		}
+		for row, values := range sheets[name] {
+			cell, _ := excelize.CoordinatesToCellName(1, row+1)
+			assert.NoError(t, f.SetSheetRow(name, cell, &values))
+		}
+	}
+	buf, err := f.WriteToBuffer()
+	assert.NoError(t, err)
+	return buf.String()
+}
+
+func TestNewUploadFileOptions(t *testing.T) {
+	testCases := []struct {
+		sheet     string
+		delimiter string
+		encoding  string
+		expected  uploadFileOptions
+		hasError  bool
+	}{
+		{"", "", "", uploadFileOptions{}, false},
+		{"Taxes", ";", "auto", uploadFileOptions{Sheet: "Taxes", Delimiter: ";"}, false},
+		{"", "tab", "TIS-620", uploadFileOptions{Delimiter: "\t", Encoding: encodingTIS620}, false},
+		{"", "", "windows-874", uploadFileOptions{Encoding: encodingWindows874}, false},
+		{"", ";;", "", uploadFileOptions{}, true},
+		{"", "\"", "", uploadFileOptions{}, true},
+		{"", "\n", "", uploadFileOptions{}, true},
+		{"", "", "latin1", uploadFileOptions{}, true},
+	}
+
+	for _, tc := range testCases {
+		options, err := newUploadFileOptions(tc.sheet, tc.delimiter, tc.encoding)
+
+		assert.Equal(t, tc.hasError, err != nil)
+		if !tc.hasError {
+			assert.Equal(t, tc.expected, options)
+		}
+	}
+}
+
+func readRows(reader rowReader) ([][]string, []int, error) {
+	records, lines := [][]string{}, []int{}
+	for {
+		record, line, err := reader.Read()
+		if err == io.EOF {
+			return records, lines, nil
+		}
+		if err != nil {
+			return records, lines, err
+		}
+		records = append(records, append([]string{}, record...))
+		lines = append(lines, line)
+	}
+}
+
+func TestNewRowReader(t *testing.T) {
+	workbook := newWorkbook(t, []string{"Summary", "Taxes"}, map[string][][]interface{}{
+		"Summary": {{"totalIncome"}, {100000}},
+		"Taxes": {
+			{"Taxes of 2567"},
+			{},
+			{"id", "totalIncome", "wht"},
+			{"A", 500000.5, 30000},
+			{},
+			{"B", 750000},
+		},
+	})
+	tis620, _ := charmap.Windows874.NewEncoder().String("id;totalIncome\nสมชาย;\"1,500,000.00\"\n")
+	testCases := []struct {
+		content  string
+		options  uploadFileOptions
+		records  [][]string
+		lines    []int
+		hasError bool
+	}{
+		{"id,totalIncome\nA,500000.0\n", uploadFileOptions{}, [][]string{{"id", "totalIncome"}, {"A", "500000.0"}}, []int{1, 2}, false},
+		{"\ufeffid,totalIncome\nA,500000.0\n", uploadFileOptions{}, [][]string{{"id", "totalIncome"}, {"A", "500000.0"}}, []int{1, 2}, false},
+		{"id;totalIncome\nA;\"1,500,000.00\"\n", uploadFileOptions{}, [][]string{{"id", "totalIncome"}, {"A", "1,500,000.00"}}, []int{1, 2}, false},
+		{"\"id;x\"\ttotalIncome\nA\t500000.0\n", uploadFileOptions{}, [][]string{{"id;x", "totalIncome"}, {"A", "500000.0"}}, []int{1, 2}, false},
+		{"totalIncome;id,x\n", uploadFileOptions{Delimiter: ";"}, [][]string{{"totalIncome", "id,x"}}, []int{1}, false},
+		{tis620, uploadFileOptions{}, [][]string{{"id", "totalIncome"}, {"สมชาย", "1,500,000.00"}}, []int{1, 2}, false},
+		{tis620, uploadFileOptions{Encoding: encodingTIS620}, [][]string{{"id", "totalIncome"}, {"สมชาย", "1,500,000.00"}}, []int{1, 2}, false},
+		{"totalIncome\x00\x01\x02", uploadFileOptions{}, nil, nil, true},
+		{workbook, uploadFileOptions{}, [][]string{{"totalIncome"}, {"100000"}}, []int{1, 2}, false},
+		{workbook, uploadFileOptions{Sheet: "Taxes"}, [][]string{{"id", "totalIncome", "wht"}, {"A", "500000.5", "30000"}, {"B", "750000", ""}}, []int{3, 4, 6}, false},
+		{workbook, uploadFileOptions{Sheet: "Other"}, nil, nil, true},
+		{newWorkbook(t, []string{"Sheet1"}, map[string][][]interface{}{"Sheet1": {{"id", "income"}, {"A", 1}}}), uploadFileOptions{}, [][]string{}, []int{}, true},
+	}
+
+	for _, tc := range testCases {
+		reader, err := newRowReader(strings.NewReader(tc.content), tc.options, testUpload.MaxBytes)
+		if err == nil {
+			var records [][]string
+			var lines []int
+			records, lines, err = readRows(reader)
+			assert.NoError(t, reader.Close())
+			if !tc.hasError {
+				assert.Equal(t, tc.records, records)
+				assert.Equal(t, tc.lines, lines)
+			}
+		}
+		assert.Equal(t, tc.hasError, err != nil)
+	}
+}
+
+func TestErrorNewRowReaderCorruptWorkbook(t *testing.T) {
+	_, err := newRowReader(bytes.NewReader(append([]byte("PK\x03\x04"), "not a zip"...)), uploadFileOptions{}, testUpload.MaxBytes)
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,101 @@
+package tax
+
+import "github.com/kidkrub/assessment-tax/internal/pkg/money"
+
+// Income is assessable income of one category of section 40 of the Revenue
+// Code, e.g. "40(1)" for salary. Expense is the actual expense claimed instead
+// of the flat rate, for the categories that allow it.
+type Income struct {
+	Category string        `json:"category"`
+	Amount   money.Amount  `json:"amount"`
+	Expense  *money.Amount `json:"expense,omitempty"`
+	// filer tells apart the incomes of each spouse on a combined return, as
+	// each spouse deducts expenses up to their own caps
+	filer int
+}
+
+type IncomeBreakdown struct {
+	Category         string       `json:"category"`
+	Income           money.Amount `json:"income"`
+	ExpenseDeduction money.Amount `json:"expenseDeduction"`
+	NetIncome        money.Amount `json:"netIncome"`
+}
+
+type expenseRule struct {
+	rate money.Rate
+	// cap is shared by every category of the same group, 0 for no cap
+	cap   money.Amount
+	group string
+	// actual allows claiming the actual expense instead of the flat rate
+	actual bool
+}
+
+// incomeCategories lists the assessable income categories in the order their
+// expenses are deducted.
+var incomeCategories = []string{"40(1)", "40(2)", "40(3)", "40(4)", "40(5)", "40(6)", "40(7)", "40(8)"}
+
+var expenseRules = map[string]expenseRule{
+	"40(1)": {rate: money.RateFromFloat(0.5), cap: money.New(100000), group: "40(1)-40(2)"},
+	"40(2)": {rate: money.RateFromFloat(0.5), cap: money.New(100000), group: "40(1)-40(2)"},
+	"40(3)": {rate: money.RateFromFloat(0.5), cap: money.New(100000), group: "40(3)"},
+	"40(4)": {},
+	"40(5)": {rate: money.RateFromFloat(0.3), actual: true},
+	"40(6)": {rate: money.RateFromFloat(0.3), actual: true},
+	"40(7)": {rate: money.RateFromFloat(0.6), actual: true},
+	"40(8)": {rate: money.RateFromFloat(0.6), actual: true},
+}
+
+// deductExpenses sums the incomes of each filer by category and deducts the
+// expense of each. The breakdown follows the order of incomeCategories, filer
+// by filer.
+func deductExpenses(incomes []Income) []IncomeBreakdown {
+	filers := map[int][]Income{}
+	lastFiler := 0
+	for _, income := range incomes {
+		filers[income.filer] = append(filers[income.filer], income)
+		lastFiler = max(lastFiler, income.filer)
+	}
+	breakdown := []IncomeBreakdown{}
+	for filer := 0; filer <= lastFiler; filer++ {
+		if filerIncomes, ok := filers[filer]; ok {
+			breakdown = append(breakdown, deductFilerExpenses(filerIncomes)...)
+		}
+	}
+	return breakdown
+}
+
+func deductFilerExpenses(incomes []Income) []IncomeBreakdown {
+	amounts := map[string]money.Amount{}
+	actuals := map[string]*money.Amount{}
+	for _, income := range incomes {
+		amounts[income.Category] += income.Amount
+		if income.Expense != nil {
+			actual := *income.Expense
+			if previous, ok := actuals[income.Category]; ok {
+				actual += *previous
+			}
+			actuals[income.Category] = &actual
+		}
+	}
+
+	breakdown := []IncomeBreakdown{}
+	used := map[string]money.Amount{}
+	for _, category := range incomeCategories {
+		amount, ok := amounts[category]
+		if !ok {
+			continue
+		}
+		rule := expenseRules[category]
+		expense := amount.Mul(rule.rate)
+		if actual, ok := actuals[category]; ok {
+			expense = *actual
+		}
+		if rule.cap > 0 {
+			expense = money.Min(expense, rule.cap-used[rule.group])
+			used[rule.group] += expense
+		}
+		expense = money.Max(money.Min(expense, amount), 0)
+		breakdown = append(breakdown, IncomeBreakdown{category, amount, expense, amount - expense})
+	}
+	return breakdown
+}
@@ -0,0 +1,69 @@
+package tax
+
+import (
+	"testing"
+
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeductExpenses(t *testing.T) {
+	// Arrange
+	expense := func(baht int64) *money.Amount {
+		amount := money.New(baht)
+		return &amount
+	}
+	testCases := []struct {
+		incomes  []Income
+		expected []IncomeBreakdown
+	}{
+		{[]Income{{Category: "40(1)", Amount: money.New(120000)}}, []IncomeBreakdown{
+			{"40(1)", money.New(120000), money.New(60000), money.New(60000)},
+		}},
+		{[]Income{{Category: "40(1)", Amount: money.New(600000)}}, []IncomeBreakdown{
+			{"40(1)", money.New(600000), money.New(100000), money.New(500000)},
+		}},
+		{[]Income{{Category: "40(8)", Amount: money.New(200000)}, {Category: "40(2)", Amount: money.New(100000)}, {Category: "40(1)", Amount: money.New(600000)}}, []IncomeBreakdown{
+			{"40(1)", money.New(600000), money.New(100000), money.New(500000)},
+			{"40(2)", money.New(100000), 0, money.New(100000)},
+			{"40(8)", money.New(200000), money.New(120000), money.New(80000)},
+		}},
+		{[]Income{{Category: "40(5)", Amount: money.New(100000), Expense: expense(50000)}, {Category: "40(4)", Amount: money.New(10000)}}, []IncomeBreakdown{
+			{"40(4)", money.New(10000), 0, money.New(10000)},
+			{"40(5)", money.New(100000), money.New(50000), money.New(50000)},
+		}},
+		{[]Income{{Category: "40(7)", Amount: money.New(100000), Expense: expense(150000)}}, []IncomeBreakdown{
+			{"40(7)", money.New(100000), money.New(100000), 0},
+		}},
+	}
+
+	for _, tc := range testCases {
+		// Act
+		actual := deductExpenses(tc.incomes)
+
+		// Assertions
+		assert.Equal(t, tc.expected, actual)
+	}
+}
+
+func TestTaxCalculateIncomes(t *testing.T) {
+	// Arrange
+	expense := money.New(50000)
+	inputData := TaxRequestObject{Incomes: []Income{
+		{Category: "40(1)", Amount: money.New(600000)},
+		{Category: "40(2)", Amount: money.New(100000)},
+		{Category: "40(5)", Amount: money.New(100000), Expense: &expense},
+		{Category: "40(8)", Amount: money.New(200000)},
+	}}
+
+	// Act
+	result := taxCalculate(inputData, taxRules[2567])
+	res := newTaxResponse(result)
+
+	// Assertions
+	assert.Equal(t, money.New(1000000), result.TotalIncome)
+	assert.Equal(t, money.New(670000), result.TaxableIncome)
+	assert.Equal(t, money.New(60500), result.Tax)
+	assert.Equal(t, money.New(939500), res.NetIncome)
+	assert.Len(t, res.Incomes, 4)
+}
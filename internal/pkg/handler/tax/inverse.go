@@ -0,0 +1,127 @@
+package tax
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
+	"github.com/labstack/echo/v4"
+)
+
+type InverseRequestObject struct {
+	TaxYear    int          `json:"taxYear,omitempty"`
+	Target     string       `json:"target"`
+	Amount     money.Amount `json:"amount"`
+	Wht        money.Amount `json:"wht"`
+	Allowances []Allowance  `json:"allowances"`
+}
+
+type IncomeRange struct {
+	Min money.Amount `json:"min"`
+	Max money.Amount `json:"max"`
+}
+
+type InverseResponseObject struct {
+	TotalIncome      money.Amount      `json:"totalIncome"`
+	TotalIncomeRange *IncomeRange      `json:"totalIncomeRange,omitempty"`
+	Exact            bool              `json:"exact"`
+	Calculation      TaxResponseObject `json:"calculation"`
+}
+
+// maxInverseIncome bounds the search for the gross income, in whole baht.
+const maxInverseIncome = 1000000000000
+
+// inverseTargets measure the target of each solvable field so the measure never
+// decreases as the income grows, which lets the income be found by bisection.
+var inverseTargets = map[string]func(inputData TaxRequestObject, result taxResult) money.Amount{
+	"tax": func(_ TaxRequestObject, result taxResult) money.Amount {
+		return money.Max(result.Tax, 0)
+	},
+	"netIncome": func(inputData TaxRequestObject, result taxResult) money.Amount {
+		return inputData.TotalIncome - result.GrossTax
+	},
+	"taxRefund": func(_ TaxRequestObject, result taxResult) money.Amount {
+		return -money.Max(-result.Tax, 0)
+	},
+}
+
+func (h handler) TaxInverseCalculateHandler(c echo.Context) error {
+	inverseRequestObject := InverseRequestObject{}
+	if err := c.Bind(&inverseRequestObject); err != nil {
+		return problem.Bind(err)
+	}
+	rule, err := h.taxRule(inverseRequestObject.TaxYear)
+	if err != nil {
+		return err
+	}
+	if errs := rule.validateInverse(inverseRequestObject); len(errs) > 0 {
+		return validationError(errs)
+	}
+	res, ok := inverseCalculate(inverseRequestObject, rule)
+	if !ok {
+		return problem.New(targetUnreachable, "no income reaches the target")
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// validateInverse checks every field of the request against the rule set and
+// reports all the violations found. The income is what's searched for, so wht
+// is only checked against the largest income searched.
+func (rule TaxRule) validateInverse(inputData InverseRequestObject) []problem.FieldError {
+	errs := []problem.FieldError{}
+	if _, ok := inverseTargets[inputData.Target]; !ok {
+		errs = append(errs, problem.NewFieldError("target", codeUnknown, "target must be one of 'tax', 'netIncome' or 'taxRefund'"))
+	}
+	if inputData.Amount < 0 {
+		errs = append(errs, negativeError("amount"))
+	}
+	return append(errs, rule.validateTaxRequest(TaxRequestObject{
+		TaxYear:     inputData.TaxYear,
+		TotalIncome: money.New(maxInverseIncome),
+		Wht:         inputData.Wht,
+		Allowances:  inputData.Allowances,
+	})...)
+}
+
+// inverseCalculate searches whole-baht incomes for the ones whose calculation
+// meets the target. The lowest income is returned together with the range of
+// incomes giving the same result when the target falls on a flat stretch, such
+// as the 0% tier. When no income hits the target exactly, the lowest income
+// passing it is returned with Exact set to false.
+func inverseCalculate(inverseRequestObject InverseRequestObject, rule TaxRule) (InverseResponseObject, bool) {
+	measure := inverseTargets[inverseRequestObject.Target]
+	target := inverseRequestObject.Amount
+	if inverseRequestObject.Target == "taxRefund" {
+		target = -target
+	}
+	requestObject := func(baht int) TaxRequestObject {
+		return TaxRequestObject{
+			TaxYear:     inverseRequestObject.TaxYear,
+			TotalIncome: money.New(int64(baht)),
+			Wht:         inverseRequestObject.Wht,
+			Allowances:  inverseRequestObject.Allowances,
+		}
+	}
+	at := func(baht int) money.Amount {
+		inputData := requestObject(baht)
+		return measure(inputData, taxCalculate(inputData, rule))
+	}
+
+	lowest := sort.Search(maxInverseIncome+1, func(baht int) bool { return at(baht) >= target })
+	if lowest > maxInverseIncome || (lowest == 0 && at(0) > target) {
+		return InverseResponseObject{}, false
+	}
+	highest := sort.Search(maxInverseIncome+1, func(baht int) bool { return at(baht) > target }) - 1
+
+	inputData := requestObject(lowest)
+	res := InverseResponseObject{
+		TotalIncome: inputData.TotalIncome,
+		Exact:       highest >= lowest,
+		Calculation: newTaxResponse(taxCalculate(inputData, rule)),
+	}
+	if highest > lowest {
+		res.TotalIncomeRange = &IncomeRange{inputData.TotalIncome, money.New(int64(highest))}
+	}
+	return res, true
+}
@@ -0,0 +1,126 @@
+package tax
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInverseCalculate(t *testing.T) {
+	// Arrange
+	rule := taxRules[2567]
+	testCases := []struct {
+		inputData InverseRequestObject
+		expected  struct {
+			totalIncome money.Amount
+			incomeRange *IncomeRange
+			exact       bool
+		}
+	}{
+		{InverseRequestObject{Target: "tax", Amount: money.New(29000)}, struct {
+			totalIncome money.Amount
+			incomeRange *IncomeRange
+			exact       bool
+		}{money.New(500000), &IncomeRange{money.New(500000), money.New(500009)}, true}},
+		{InverseRequestObject{Target: "tax", Amount: 0}, struct {
+			totalIncome money.Amount
+			incomeRange *IncomeRange
+			exact       bool
+		}{0, &IncomeRange{0, money.New(210009)}, true}},
+		{InverseRequestObject{Target: "tax", Amount: money.New(29000), Allowances: []Allowance{{AllowanceType: "donation", Amount: money.New(100000)}}}, struct {
+			totalIncome money.Amount
+			incomeRange *IncomeRange
+			exact       bool
+		}{money.New(600000), &IncomeRange{money.New(600000), money.New(600009)}, true}},
+		{InverseRequestObject{Target: "tax", Amount: money.New(29000) + 50}, struct {
+			totalIncome money.Amount
+			incomeRange *IncomeRange
+			exact       bool
+		}{money.New(500010), nil, false}},
+		{InverseRequestObject{Target: "netIncome", Amount: money.New(1000000)}, struct {
+			totalIncome money.Amount
+			incomeRange *IncomeRange
+			exact       bool
+		}{money.New(1122499), &IncomeRange{money.New(1122499), money.New(1122500)}, true}},
+		{InverseRequestObject{Target: "taxRefund", Amount: money.New(1000), Wht: money.New(30000)}, struct {
+			totalIncome money.Amount
+			incomeRange *IncomeRange
+			exact       bool
+		}{money.New(500000), &IncomeRange{money.New(500000), money.New(500009)}, true}},
+	}
+
+	for _, tc := range testCases {
+		// Act
+		actual, ok := inverseCalculate(tc.inputData, rule)
+		// Assert
+		assert.True(t, ok)
+		assert.Equal(t, tc.expected.totalIncome, actual.TotalIncome, "income is incorrect for %s %s", tc.inputData.Target, tc.inputData.Amount)
+		assert.Equal(t, tc.expected.incomeRange, actual.TotalIncomeRange, "range is incorrect for %s %s", tc.inputData.Target, tc.inputData.Amount)
+		assert.Equal(t, tc.expected.exact, actual.Exact, "exact is incorrect for %s %s", tc.inputData.Target, tc.inputData.Amount)
+	}
+}
+
+func TestInverseCalculateUnreachable(t *testing.T) {
+	_, ok := inverseCalculate(InverseRequestObject{Target: "taxRefund", Amount: money.New(5000), Wht: money.New(1000)}, taxRules[2567])
+
+	assert.False(t, ok)
+}
+
+func TestTaxInverseCalculateHandler(t *testing.T) {
+	// Arrange
+	reqBody := `{"taxYear":2567,"target":"tax","amount":29000.0,"wht":0.0,"allowances":[]}`
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(reqBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	mock.ExpectQuery("SELECT lower_bound, upper_bound, rate FROM \"tax_brackets\" WHERE tax_year = $1 ORDER BY lower_bound;").WillReturnRows(sqlmock.NewRows([]string{"lower_bound", "upper_bound", "rate"}))
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.TaxInverseCalculateHandler(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"totalIncome":500000,"totalIncomeRange":{"min":500000,"max":500009},"exact":true,"calculation":{"tax":29000,"taxableIncome":440000,"netIncome":471000,"effectiveRate":0.058,"marginalRate":0.1,"marginalLevel":"150,001-500,000","taxMethod":"progressive","progressiveTax":29000,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000,"rate":0,"tax":0},{"level":"150,001-500,000","income":290000,"rate":0.1,"tax":29000},{"level":"500,001-1,000,000","income":0,"rate":0.15,"tax":0},{"level":"1,000,001-2,000,000","income":0,"rate":0.2,"tax":0},{"level":"2,000,001 ขึ้นไป","income":0,"rate":0.35,"tax":0}]}}`, rec.Body.String())
+	}
+}
+
+func TestErrorTaxInverseCalculateHandler(t *testing.T) {
+	testCases := []struct {
+		reqBody     string
+		expectedErr error
+	}{
+		{`{"taxYear":2567,"target":"income","amount":1000.0}`, validationError([]problem.FieldError{
+			problem.NewFieldError("target", codeUnknown, "target must be one of 'tax', 'netIncome' or 'taxRefund'"),
+		})},
+		{`{"taxYear":2567,"target":"tax","amount":-1.0,"wht":-1.0,"allowances":[{"allowanceType":"donation","amount":-1.0}]}`, validationError([]problem.FieldError{
+			negativeError("amount"),
+			negativeError("wht"),
+			negativeError("allowances[0].amount"),
+		})},
+	}
+
+	for _, tc := range testCases {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.reqBody))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		mock.ExpectQuery("SELECT lower_bound, upper_bound, rate FROM \"tax_brackets\" WHERE tax_year = $1 ORDER BY lower_bound;").WithArgs(2567).WillReturnRows(sqlmock.NewRows([]string{"lower_bound", "upper_bound", "rate"}))
+		h := newTestHandler(db)
+
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expectedErr, h.TaxInverseCalculateHandler(c))
+	}
+}
@@ -0,0 +1,133 @@
+package tax
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
+	"github.com/labstack/echo/v4"
+)
+
+// JointRequestObject carries the returns of a married couple. The tax year of
+// the couple applies to both returns, which may only repeat it.
+type JointRequestObject struct {
+	TaxYear  int              `json:"taxYear,omitempty"`
+	Taxpayer TaxRequestObject `json:"taxpayer"`
+	Spouse   TaxRequestObject `json:"spouse"`
+}
+
+type SeparateFilingObject struct {
+	Taxpayer  TaxResponseObject `json:"taxpayer"`
+	Spouse    TaxResponseObject `json:"spouse"`
+	Tax       money.Amount      `json:"tax"`
+	TaxRefund money.Amount      `json:"taxRefund,omitempty"`
+}
+
+type JointResponseObject struct {
+	Separate SeparateFilingObject `json:"separate"`
+	Combined TaxResponseObject    `json:"combined"`
+	// Filing is the cheaper option, "separate" or "combined"
+	Filing    string       `json:"filing"`
+	TaxSaving money.Amount `json:"taxSaving"`
+}
+
+const (
+	filingSeparate = "separate"
+	filingCombined = "combined"
+)
+
+func (h handler) TaxJointCalculateHandler(c echo.Context) error {
+	jointRequestObject := JointRequestObject{}
+	if err := c.Bind(&jointRequestObject); err != nil {
+		return problem.Bind(err)
+	}
+	rule, err := h.taxRule(jointRequestObject.TaxYear)
+	if err != nil {
+		return err
+	}
+	if errs := validateJointRequest(jointRequestObject, rule); len(errs) > 0 {
+		return validationError(errs)
+	}
+	return c.JSON(http.StatusOK, jointCalculate(jointRequestObject, rule))
+}
+
+func validateJointRequest(jointRequestObject JointRequestObject, rule TaxRule) []problem.FieldError {
+	errs := []problem.FieldError{}
+	filers := []struct {
+		prefix    string
+		inputData TaxRequestObject
+	}{{"taxpayer.", jointRequestObject.Taxpayer}, {"spouse.", jointRequestObject.Spouse}}
+	for _, filer := range filers {
+		prefix, inputData := filer.prefix, filer.inputData
+		errs = append(errs, prefixFields(prefix, rule.validateTaxRequest(inputData))...)
+		if inputData.TaxYear != 0 && inputData.TaxYear != rule.Year {
+			errs = append(errs, problem.NewFieldError(prefix+"taxYear", codeInconsistent, fmt.Sprintf("taxYear must be the tax year of the couple, %d", rule.Year)))
+		}
+		for i, allowance := range inputData.Allowances {
+			if allowance.AllowanceType == "spouse" {
+				errs = append(errs, problem.NewFieldError(fmt.Sprintf("%sallowances[%d].allowanceType", prefix, i), codeNotAllowed, "the spouse allowance is applied by the filing and must not be claimed"))
+			}
+		}
+	}
+	if (len(jointRequestObject.Taxpayer.Incomes) > 0) != (len(jointRequestObject.Spouse.Incomes) > 0) {
+		errs = append(errs, problem.NewFieldError("spouse.incomes", codeInconsistent, "taxpayer and spouse must both use either totalIncome or incomes"))
+	}
+	return errs
+}
+
+// jointCalculate compares the couple filing separately with filing combined.
+// Filing separately, one spouse claims the spouse allowance when the other has
+// no income. Filing combined, the incomes and allowances are added together
+// and the spouse allowance is claimed in place of the second personal
+// deduction, while each spouse keeps their own expense and allowance caps.
+func jointCalculate(jointRequestObject JointRequestObject, rule TaxRule) JointResponseObject {
+	taxpayer, spouse := jointRequestObject.Taxpayer, jointRequestObject.Spouse
+	taxpayer.TaxYear, spouse.TaxYear = jointRequestObject.TaxYear, jointRequestObject.TaxYear
+
+	taxpayerResult, spouseResult := taxCalculate(taxpayer, rule), taxCalculate(spouse, rule)
+	if spouseResult.TotalIncome == 0 {
+		taxpayerResult = taxCalculate(withSpouseAllowance(taxpayer, rule), rule)
+	} else if taxpayerResult.TotalIncome == 0 {
+		spouseResult = taxCalculate(withSpouseAllowance(spouse, rule), rule)
+	}
+	res := JointResponseObject{}
+	res.Separate.Taxpayer = newTaxResponse(taxpayerResult)
+	res.Separate.Spouse = newTaxResponse(spouseResult)
+	res.Separate.Tax, res.Separate.TaxRefund = splitTax(taxpayerResult.Tax + spouseResult.Tax)
+
+	incomes := append([]Income{}, taxpayer.Incomes...)
+	for _, income := range spouse.Incomes {
+		income.filer = 1
+		incomes = append(incomes, income)
+	}
+	allowances := append([]Allowance{}, taxpayer.Allowances...)
+	for _, allowance := range spouse.Allowances {
+		allowance.filer = 1
+		allowances = append(allowances, allowance)
+	}
+	combined := TaxRequestObject{
+		TaxYear:      jointRequestObject.TaxYear,
+		TotalIncome:  taxpayer.TotalIncome + spouse.TotalIncome,
+		Incomes:      incomes,
+		Wht:          taxpayer.Wht + spouse.Wht,
+		Allowances:   allowances,
+		filerIncomes: []money.Amount{taxpayerResult.TotalIncome, spouseResult.TotalIncome},
+	}
+	combinedResult := taxCalculate(withSpouseAllowance(combined, rule), rule)
+	res.Combined = newTaxResponse(combinedResult)
+
+	separateTax := taxpayerResult.GrossTax + spouseResult.GrossTax
+	res.Filing = filingSeparate
+	if combinedResult.GrossTax < separateTax {
+		res.Filing = filingCombined
+	}
+	res.TaxSaving = money.Max(separateTax-combinedResult.GrossTax, combinedResult.GrossTax-separateTax)
+	return res
+}
+
+func withSpouseAllowance(inputData TaxRequestObject, rule TaxRule) TaxRequestObject {
+	spouse := Allowance{AllowanceType: "spouse", Amount: rule.allowanceCap("spouse", 0, 0)}
+	inputData.Allowances = append(append([]Allowance{}, inputData.Allowances...), spouse)
+	return inputData
+}
@@ -0,0 +1,124 @@
+package tax
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJointCalculate(t *testing.T) {
+	// Arrange
+	rule := taxRules[2567]
+	testCases := []struct {
+		inputData JointRequestObject
+		expected  struct {
+			taxpayerTax money.Amount
+			spouseTax   money.Amount
+			combinedTax money.Amount
+			filing      string
+			taxSaving   money.Amount
+		}
+	}{
+		{JointRequestObject{Taxpayer: TaxRequestObject{TotalIncome: money.New(1000000)}}, struct {
+			taxpayerTax money.Amount
+			spouseTax   money.Amount
+			combinedTax money.Amount
+			filing      string
+			taxSaving   money.Amount
+		}{money.New(92000), 0, money.New(92000), filingSeparate, 0}},
+		{JointRequestObject{Taxpayer: TaxRequestObject{TotalIncome: money.New(600000)}, Spouse: TaxRequestObject{TotalIncome: money.New(600000)}}, struct {
+			taxpayerTax money.Amount
+			spouseTax   money.Amount
+			combinedTax money.Amount
+			filing      string
+			taxSaving   money.Amount
+		}{money.New(41000), money.New(41000), money.New(126000), filingSeparate, money.New(44000)}},
+		{JointRequestObject{Taxpayer: TaxRequestObject{TotalIncome: money.New(100000), Allowances: []Allowance{{AllowanceType: "home-loan-interest", Amount: money.New(100000)}}}, Spouse: TaxRequestObject{TotalIncome: money.New(500000)}}, struct {
+			taxpayerTax money.Amount
+			spouseTax   money.Amount
+			combinedTax money.Amount
+			filing      string
+			taxSaving   money.Amount
+		}{0, money.New(29000), money.New(23000), filingCombined, money.New(6000)}},
+		{JointRequestObject{Taxpayer: TaxRequestObject{Incomes: []Income{{Category: "40(1)", Amount: money.New(300000)}}}, Spouse: TaxRequestObject{Incomes: []Income{{Category: "40(1)", Amount: money.New(300000)}}}}, struct {
+			taxpayerTax money.Amount
+			spouseTax   money.Amount
+			combinedTax money.Amount
+			filing      string
+			taxSaving   money.Amount
+		}{0, 0, money.New(13000), filingSeparate, money.New(13000)}},
+		{JointRequestObject{Taxpayer: TaxRequestObject{TotalIncome: money.New(1000000), Allowances: []Allowance{{AllowanceType: "life-insurance", Amount: money.New(100000)}, {AllowanceType: "k-receipt", Amount: money.New(50000)}}}, Spouse: TaxRequestObject{TotalIncome: money.New(1000000), Allowances: []Allowance{{AllowanceType: "life-insurance", Amount: money.New(100000)}, {AllowanceType: "k-receipt", Amount: money.New(50000)}}}}, struct {
+			taxpayerTax money.Amount
+			spouseTax   money.Amount
+			combinedTax money.Amount
+			filing      string
+			taxSaving   money.Amount
+		}{money.New(78500), money.New(78500), money.New(226000), filingSeparate, money.New(69000)}},
+	}
+
+	for _, tc := range testCases {
+		// Act
+		actual := jointCalculate(tc.inputData, rule)
+
+		// Assertions
+		assert.Equal(t, tc.expected.taxpayerTax, actual.Separate.Taxpayer.Tax)
+		assert.Equal(t, tc.expected.spouseTax, actual.Separate.Spouse.Tax)
+		assert.Equal(t, tc.expected.taxpayerTax+tc.expected.spouseTax, actual.Separate.Tax)
+		assert.Equal(t, tc.expected.combinedTax, actual.Combined.Tax)
+		assert.Equal(t, tc.expected.filing, actual.Filing)
+		assert.Equal(t, tc.expected.taxSaving, actual.TaxSaving)
+	}
+}
+
+func TestErrorTaxJointCalculateHandler(t *testing.T) {
+	testCases := []struct {
+		reqBody  string
+		expected error
+	}{
+		{`{"taxpayer":{"totalIncome":500000.0,"allowances":[{"allowanceType":"spouse","amount":60000.0}]},"spouse":{"totalIncome":0.0}}`, validationError([]problem.FieldError{problem.NewFieldError("taxpayer.allowances[0].allowanceType", codeNotAllowed, "the spouse allowance is applied by the filing and must not be claimed")})},
+		{`{"taxpayer":{"totalIncome":500000.0},"spouse":{"incomes":[{"category":"40(1)","amount":300000.0}]}}`, validationError([]problem.FieldError{problem.NewFieldError("spouse.incomes", codeInconsistent, "taxpayer and spouse must both use either totalIncome or incomes")})},
+		{`{"taxpayer":{"taxYear":2569,"totalIncome":500000.0},"spouse":{"taxYear":2567,"totalIncome":300000.0}}`, validationError([]problem.FieldError{problem.NewFieldError("spouse.taxYear", codeInconsistent, "taxYear must be the tax year of the couple, 2569")})},
+	}
+
+	for _, tc := range testCases {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.reqBody))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		mock.ExpectQuery("SELECT lower_bound, upper_bound, rate FROM \"tax_brackets\" WHERE tax_year = $1 ORDER BY lower_bound;").WillReturnRows(sqlmock.NewRows([]string{"lower_bound", "upper_bound", "rate"}))
+		mock.ExpectQuery("SELECT maxAmount FROM \"deductions\" WHERE \"name\" = $1;").WithArgs("personal").WillReturnRows(sqlmock.NewRows([]string{"maxAmount"}).AddRow(60000.0))
+		mock.ExpectQuery("SELECT maxAmount FROM \"deductions\" WHERE \"name\" = $1;").WithArgs("k-receipt").WillReturnRows(sqlmock.NewRows([]string{"maxAmount"}).AddRow(50000.0))
+		h := newTestHandler(db)
+
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, h.TaxJointCalculateHandler(c))
+	}
+}
+
+func TestJointCalculateCapsAllowancesPerSpouse(t *testing.T) {
+	// Arrange
+	rule := taxRules[2567]
+	allowances := []Allowance{{AllowanceType: "life-insurance", Amount: money.New(100000)}, {AllowanceType: "k-receipt", Amount: money.New(50000)}}
+	inputData := JointRequestObject{
+		Taxpayer: TaxRequestObject{TotalIncome: money.New(1000000), Allowances: allowances},
+		Spouse:   TaxRequestObject{TotalIncome: money.New(1000000), Allowances: allowances},
+	}
+
+	// Act
+	actual := jointCalculate(inputData, rule)
+
+	// Assertions
+	assert.Equal(t, money.New(790000), actual.Separate.Taxpayer.TaxableIncome)
+	assert.Equal(t, money.New(790000), actual.Separate.Spouse.TaxableIncome)
+	assert.Equal(t, money.New(1580000), actual.Combined.TaxableIncome)
+}
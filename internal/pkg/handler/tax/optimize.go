@@ -0,0 +1,116 @@
+package tax
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
+	"github.com/labstack/echo/v4"
+)
+
+type AllowanceRecommendation struct {
+	AllowanceType   string       `json:"allowanceType"`
+	Claimed         money.Amount `json:"claimed"`
+	Cap             money.Amount `json:"cap"`
+	RemainingCap    money.Amount `json:"remainingCap"`
+	UsefulAmount    money.Amount `json:"usefulAmount"`
+	TaxSavedPerBaht money.Rate   `json:"taxSavedPerBaht"`
+	TaxSaving       money.Amount `json:"taxSaving"`
+}
+
+type OptimizeResponseObject struct {
+	Tax              money.Amount              `json:"tax"`
+	TaxRefund        money.Amount              `json:"taxRefund,omitempty"`
+	TaxIfMaxed       money.Amount              `json:"taxIfMaxed"`
+	TaxRefundIfMaxed money.Amount              `json:"taxRefundIfMaxed,omitempty"`
+	TaxSaving        money.Amount              `json:"taxSaving"`
+	Allowances       []AllowanceRecommendation `json:"allowances"`
+}
+
+// optimizableAllowances are the allowances bought by spending, so claiming more
+// of them is up to the taxpayer.
+var optimizableAllowances = []string{"donation", "k-receipt"}
+
+func (h handler) TaxOptimizeHandler(c echo.Context) error {
+	taxRequestObject := TaxRequestObject{}
+	if err := c.Bind(&taxRequestObject); err != nil {
+		return problem.Bind(err)
+	}
+	rule, err := h.taxRule(taxRequestObject.TaxYear)
+	if err != nil {
+		return err
+	}
+	if errs := rule.validateTaxRequest(taxRequestObject); len(errs) > 0 {
+		return validationError(errs)
+	}
+	return c.JSON(http.StatusOK, optimizeAllowances(taxRequestObject, rule))
+}
+
+// optimizeAllowances reports how much more of each optimizable allowance still
+// lowers the tax before its cap, or before the taxable income reaches the 0%
+// tier, and what the tax would be with every cap maxed.
+func optimizeAllowances(inputData TaxRequestObject, rule TaxRule) OptimizeResponseObject {
+	current := taxCalculate(inputData, rule)
+	res := OptimizeResponseObject{}
+	res.Tax, res.TaxRefund = splitTax(current.Tax)
+
+	maxed := inputData
+	maxed.Allowances = append([]Allowance{}, inputData.Allowances...)
+	for _, allowanceType := range optimizableAllowances {
+		claimed := money.Amount(0)
+		for _, allowance := range inputData.Allowances {
+			if allowance.AllowanceType == allowanceType {
+				claimed += allowance.Amount
+			}
+		}
+		cap := rule.allowanceCap(allowanceType, 0, current.TotalIncome)
+		if rule.Allowances[allowanceType].donation {
+			cap = rule.donationCap(inputData, allowanceType)
+		}
+		remaining := money.Max(cap-claimed, 0)
+		withExtra := func(extra money.Amount) taxResult {
+			extended := inputData
+			extended.Allowances = append(append([]Allowance{}, inputData.Allowances...), Allowance{AllowanceType: allowanceType, Amount: extra})
+			return taxCalculate(extended, rule)
+		}
+		lowestResult := withExtra(remaining)
+		// compare the tax before the satang are dropped, otherwise the last few
+		// baht under each whole baht of tax look useless
+		usefulBaht := sort.Search(int(remaining/money.Baht)+1, func(baht int) bool {
+			return withExtra(money.New(int64(baht))).assessedTax() <= lowestResult.assessedTax()
+		})
+		recommendation := AllowanceRecommendation{
+			AllowanceType: allowanceType,
+			Claimed:       claimed,
+			Cap:           cap,
+			RemainingCap:  remaining,
+			UsefulAmount:  money.Min(money.New(int64(usefulBaht)), remaining),
+			TaxSaving:     current.GrossTax - lowestResult.GrossTax,
+		}
+		if marginal := current.marginalLevel(); marginal != nil && recommendation.UsefulAmount > 0 {
+			recommendation.TaxSavedPerBaht = marginal.Rate
+		}
+		res.Allowances = append(res.Allowances, recommendation)
+		maxed.Allowances = append(maxed.Allowances, Allowance{AllowanceType: allowanceType, Amount: remaining})
+	}
+
+	maxedResult := taxCalculate(maxed, rule)
+	res.TaxIfMaxed, res.TaxRefundIfMaxed = splitTax(maxedResult.Tax)
+	res.TaxSaving = current.GrossTax - maxedResult.GrossTax
+	return res
+}
+
+// donationCap returns the cap donationSteps applies to a donation of
+// inputData, which under the statutory rules is a share of the income left
+// after the other allowances.
+func (rule TaxRule) donationCap(inputData TaxRequestObject, allowanceType string) money.Amount {
+	withDonation := inputData
+	withDonation.Allowances = append(append([]Allowance{}, inputData.Allowances...), Allowance{AllowanceType: allowanceType})
+	for _, step := range taxCalculate(withDonation, rule).Steps {
+		if step.Step == stepAllowance && step.AllowanceType == allowanceType {
+			return *step.Cap
+		}
+	}
+	return 0
+}
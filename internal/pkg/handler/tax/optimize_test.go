@@ -0,0 +1,103 @@
+package tax
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptimizeAllowances(t *testing.T) {
+	// Arrange
+	rule := taxRules[2567]
+	testCases := []struct {
+		inputData TaxRequestObject
+		expected  OptimizeResponseObject
+	}{
+		{TaxRequestObject{TotalIncome: money.New(500000), Allowances: []Allowance{{AllowanceType: "donation", Amount: money.New(50000)}}}, OptimizeResponseObject{
+			Tax:        money.New(24000),
+			TaxIfMaxed: money.New(14000),
+			TaxSaving:  money.New(10000),
+			Allowances: []AllowanceRecommendation{
+				{"donation", money.New(50000), money.New(100000), money.New(50000), money.New(50000), money.RateFromFloat(0.1), money.New(5000)},
+				{"k-receipt", 0, money.New(50000), money.New(50000), money.New(50000), money.RateFromFloat(0.1), money.New(5000)},
+			},
+		}},
+		{TaxRequestObject{TotalIncome: money.New(250000), Wht: money.New(5000)}, OptimizeResponseObject{
+			Tax:              0,
+			TaxRefund:        money.New(1000),
+			TaxIfMaxed:       0,
+			TaxRefundIfMaxed: money.New(5000),
+			TaxSaving:        money.New(4000),
+			Allowances: []AllowanceRecommendation{
+				{"donation", 0, money.New(100000), money.New(100000), money.New(40000), money.RateFromFloat(0.1), money.New(4000)},
+				{"k-receipt", 0, money.New(50000), money.New(50000), money.New(40000), money.RateFromFloat(0.1), money.New(4000)},
+			},
+		}},
+		{TaxRequestObject{TotalIncome: money.New(200000), Allowances: []Allowance{{AllowanceType: "donation", Amount: money.New(200000)}, {AllowanceType: "k-receipt", Amount: money.New(50000)}}}, OptimizeResponseObject{
+			Allowances: []AllowanceRecommendation{
+				{"donation", money.New(200000), money.New(100000), 0, 0, 0, 0},
+				{"k-receipt", money.New(50000), money.New(50000), 0, 0, 0, 0},
+			},
+		}},
+	}
+
+	for _, tc := range testCases {
+		// Act
+		actual := optimizeAllowances(tc.inputData, rule)
+		// Assert
+		assert.Equal(t, tc.expected, actual, "optimization is incorrect for %s case", tc.inputData.TotalIncome)
+	}
+}
+
+func TestOptimizeAllowancesStatutoryDonation(t *testing.T) {
+	// Arrange
+	rule := taxRules[2567]
+	rule.DonationMode = donationModeStatutory
+	inputData := TaxRequestObject{TotalIncome: money.New(5000000)}
+
+	// Act
+	actual := optimizeAllowances(inputData, rule)
+
+	// Assert
+	assert.Equal(t, OptimizeResponseObject{
+		Tax:        money.New(1339000),
+		TaxIfMaxed: money.New(1150350),
+		TaxSaving:  money.New(188650),
+		Allowances: []AllowanceRecommendation{
+			{"donation", 0, money.New(494000), money.New(494000), money.New(494000), money.RateFromFloat(0.35), money.New(172900)},
+			{"k-receipt", 0, money.New(50000), money.New(50000), money.New(50000), money.RateFromFloat(0.35), money.New(17500)},
+		},
+	}, actual)
+}
+
+func TestTaxOptimizeHandler(t *testing.T) {
+	// Arrange
+	reqBody := `{"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":50000.0}]}`
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(reqBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	mock.ExpectQuery("SELECT lower_bound, upper_bound, rate FROM \"tax_brackets\" WHERE tax_year = $1 ORDER BY lower_bound;").WithArgs(sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"lower_bound", "upper_bound", "rate"}))
+	mock.ExpectQuery("SELECT maxAmount FROM \"deductions\" WHERE \"name\" = $1;").WithArgs("personal").WillReturnRows(sqlmock.NewRows([]string{"maxAmount"}).AddRow(60000.0))
+	mock.ExpectQuery("SELECT maxAmount FROM \"deductions\" WHERE \"name\" = $1;").WithArgs("k-receipt").WillReturnRows(sqlmock.NewRows([]string{"maxAmount"}).AddRow(70000.0))
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.TaxOptimizeHandler(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"tax":24000,"taxIfMaxed":12000,"taxSaving":12000,"allowances":[
+			{"allowanceType":"donation","claimed":50000,"cap":100000,"remainingCap":50000,"usefulAmount":50000,"taxSavedPerBaht":0.1,"taxSaving":5000},
+			{"allowanceType":"k-receipt","claimed":0,"cap":70000,"remainingCap":70000,"usefulAmount":70000,"taxSavedPerBaht":0.1,"taxSaving":7000}
+		]}`, rec.Body.String())
+	}
+}
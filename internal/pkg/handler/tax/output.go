@@ -0,0 +1,471 @@
+package tax
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
+	"github.com/labstack/echo/v4"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	MIMEApplicationNDJSON = "application/x-ndjson"
+	MIMETextCSV           = "text/csv"
+	MIMEApplicationXLSX   = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+type TaxUploadResultObject struct {
+	Summary TaxUploadSummary          `json:"summary"`
+	Taxes   []TaxUploadResponseObject `json:"taxes"`
+}
+
+// uploadOutput writes the results of an upload as they are calculated. end
+// is called once after the last row with the error that stopped the upload,
+// if any.
+type uploadOutput interface {
+	writeRow(row TaxUploadResponseObject) error
+	end(summary TaxUploadSummary, err error) error
+	// streaming tells whether the rows are sent before the upload ends
+	streaming() bool
+}
+
+// utf8BOM lets spreadsheets such as Excel tell a CSV file is UTF-8.
+const utf8BOM = "\ufeff"
+
+// uploadOutputOptions shape the CSV and XLSX outputs. Header is the header of the
+// uploaded file and levels the labels of the tax levels to add a column for.
+// summary adds the summary section after the rows of the CSV output; the XLSX
+// output always has it, on a sheet of its own.
+type uploadOutputOptions struct {
+	header  []string
+	levels  []string
+	bom     bool
+	summary bool
+}
+
+// readUploadOutputOptions reads the options of the output from the query,
+// levels, bom and summary. The level columns follow the brackets of taxYear in
+// rules.
+func readUploadOutputOptions(c echo.Context, rules ruleSource, header []string, taxYear int) (uploadOutputOptions, error) {
+	options := uploadOutputOptions{header: header}
+	levels, err := queryBool(c, "levels")
+	if err != nil {
+		return options, err
+	}
+	if options.bom, err = queryBool(c, "bom"); err != nil {
+		return options, err
+	}
+	if options.summary, err = queryBool(c, "summary"); err != nil {
+		return options, err
+	}
+	if levels {
+		rule, err := rules.taxRule(taxYear)
+		if err != nil {
+			return options, err
+		}
+		for _, level := range rule.Levels {
+			options.levels = append(options.levels, level.level)
+		}
+	}
+	return options, nil
+}
+
+func queryBool(c echo.Context, name string) (bool, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, problem.Newf(problem.BadRequest, "%s must be true or false", name)
+	}
+	return b, nil
+}
+
+// newUploadOutput picks the output of an upload from the Accept header of the
+// request. JSON and XLSX are buffered, NDJSON and CSV are streamed.
+func newUploadOutput(c echo.Context, options uploadOutputOptions) uploadOutput {
+	switch negotiate(c.Request().Header.Get(echo.HeaderAccept), echo.MIMEApplicationJSON, MIMEApplicationNDJSON, MIMETextCSV, MIMEApplicationXLSX) {
+	case MIMEApplicationNDJSON:
+		return &ndjsonUploadOutput{c: c, encoder: json.NewEncoder(c.Response())}
+	case MIMETextCSV:
+		return &csvUploadOutput{c: c, writer: csv.NewWriter(c.Response()), options: options}
+	case MIMEApplicationXLSX:
+		return &xlsxUploadOutput{c: c, options: options}
+	}
+	return &jsonUploadOutput{c: c, res: TaxUploadResultObject{Taxes: []TaxUploadResponseObject{}}}
+}
+
+// negotiate returns the media type of accept with the highest quality that is
+// one of offers, the first of them on a tie, or the first offer when there's
+// none.
+func negotiate(accept string, offers ...string) string {
+	best, bestQuality := offers[0], 0.0
+	for _, value := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(value)
+		if err != nil || !slices.Contains(offers, mediaType) {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = mediaType, quality
+		}
+	}
+	return best
+}
+
+type jsonUploadOutput struct {
+	c   echo.Context
+	res TaxUploadResultObject
+}
+
+func (o *jsonUploadOutput) writeRow(row TaxUploadResponseObject) error {
+	o.res.Taxes = append(o.res.Taxes, row)
+	return nil
+}
+
+func (o *jsonUploadOutput) end(summary TaxUploadSummary, err error) error {
+	if err != nil {
+		return err
+	}
+	o.res.Summary = summary
+	if summary.Failed > 0 {
+		return o.c.JSON(http.StatusMultiStatus, o.res)
+	}
+	return o.c.JSON(http.StatusOK, o.res)
+}
+
+func (o *jsonUploadOutput) streaming() bool {
+	return false
+}
+
+// ndjsonUploadOutput writes a line for each row, then a line with either the
+// summary or the problem that stopped the upload.
+type ndjsonUploadOutput struct {
+	c       echo.Context
+	encoder *json.Encoder
+}
+
+func (o *ndjsonUploadOutput) writeRow(row TaxUploadResponseObject) error {
+	commit(o.c, MIMEApplicationNDJSON)
+	if err := o.encoder.Encode(row); err != nil {
+		return err
+	}
+	o.c.Response().Flush()
+	return nil
+}
+
+func (o *ndjsonUploadOutput) end(summary TaxUploadSummary, err error) error {
+	if err != nil && !o.c.Response().Committed {
+		return err
+	}
+	commit(o.c, MIMEApplicationNDJSON)
+	if err != nil {
+		logServerError(o.c, err)
+		return o.encoder.Encode(struct {
+			Error problem.Problem `json:"error"`
+		}{problem.FromError(err, o.c)})
+	}
+	return o.encoder.Encode(struct {
+		Summary TaxUploadSummary `json:"summary"`
+	}{summary})
+}
+
+func (o *ndjsonUploadOutput) streaming() bool {
+	return true
+}
+
+// csvUploadOutput writes each row as its input cells followed by the tax,
+// the tax of each level when asked for, and the status, then the summary when
+// asked for after a blank record. The problem that stopped the upload is
+// written as a last record with the status "error".
+type csvUploadOutput struct {
+	c       echo.Context
+	writer  *csv.Writer
+	options uploadOutputOptions
+}
+
+func (o *csvUploadOutput) writeHeader() {
+	commit(o.c, MIMETextCSV)
+	if o.options.bom {
+		o.c.Response().Write([]byte(utf8BOM))
+	}
+	o.writer.Write(o.options.columns())
+}
+
+// columns returns the header of the CSV and XLSX outputs.
+func (options uploadOutputOptions) columns() []string {
+	columns := append(slices.Clone(options.header), "tax", "taxRefund")
+	columns = append(columns, options.levels...)
+	return append(columns, "status", "errors")
+}
+
+// levelTax returns the tax of row at the level labelled label, nil when the
+// row has none.
+func levelTax(row TaxUploadResponseObject, label string) *money.Amount {
+	for _, level := range row.taxLevels {
+		if level.Level == label {
+			return &level.Tax
+		}
+	}
+	return nil
+}
+
+// rowMessages joins the messages of the errors of row.
+func rowMessages(row TaxUploadResponseObject) string {
+	messages := []string{}
+	for _, rowErr := range row.Errors {
+		messages = append(messages, rowErr.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (o *csvUploadOutput) writeRow(row TaxUploadResponseObject) error {
+	if !o.c.Response().Committed {
+		o.writeHeader()
+	}
+	record := make([]string, len(o.options.header), len(o.options.header)+len(o.options.levels)+4)
+	copy(record, row.record)
+	tax, taxRefund := "", ""
+	if row.Tax != nil {
+		tax, taxRefund = row.Tax.String(), row.TaxRefund.String()
+	}
+	record = append(record, tax, taxRefund)
+	for _, label := range o.options.levels {
+		tax := ""
+		if amount := levelTax(row, label); amount != nil {
+			tax = amount.String()
+		}
+		record = append(record, tax)
+	}
+	return o.flush(append(record, row.Status, rowMessages(row)))
+}
+
+func (o *csvUploadOutput) end(summary TaxUploadSummary, err error) error {
+	if err == nil {
+		return o.writeSummary(summary)
+	}
+	if !o.c.Response().Committed {
+		return err
+	}
+	logServerError(o.c, err)
+	record := make([]string, len(o.options.header)+len(o.options.levels)+2)
+	return o.flush(append(record, rowStatusError, problem.FromError(err, o.c).Detail))
+}
+
+func (o *csvUploadOutput) writeSummary(summary TaxUploadSummary) error {
+	if !o.options.summary {
+		return nil
+	}
+	if !o.c.Response().Committed {
+		o.writeHeader()
+	}
+	o.writer.Write([]string{})
+	for _, row := range summaryRows(summary) {
+		record := []string{}
+		for _, value := range row {
+			switch value := value.(type) {
+			case string:
+				record = append(record, value)
+			case int:
+				record = append(record, strconv.Itoa(value))
+			case fmt.Stringer:
+				record = append(record, value.String())
+			}
+		}
+		o.writer.Write(record)
+	}
+	return o.flush(nil)
+}
+
+func (o *csvUploadOutput) flush(record []string) error {
+	if record != nil {
+		o.writer.Write(record)
+	}
+	o.writer.Flush()
+	if err := o.writer.Error(); err != nil {
+		return err
+	}
+	o.c.Response().Flush()
+	return nil
+}
+
+func (o *csvUploadOutput) streaming() bool {
+	return true
+}
+
+// xlsxSheet and xlsxSummarySheet are the sheets of the XLSX output. Its
+// amounts are shown with the built-in number format xlsxAmountFormat,
+// "#,##0.00", and its rates with xlsxRateFormat, "0.00%".
+const (
+	xlsxSheet        = "Taxes"
+	xlsxSummarySheet = "Summary"
+	xlsxAmountFormat = 4
+	xlsxRateFormat   = 10
+)
+
+// xlsxUploadOutput writes the columns of the CSV output into a workbook, with
+// the amounts as numbers, and the summary on a sheet of its own. The workbook
+// is sent once complete, so a problem that stops the upload is the response
+// itself.
+type xlsxUploadOutput struct {
+	c       echo.Context
+	options uploadOutputOptions
+	file    *excelize.File
+	stream  *excelize.StreamWriter
+	amount  int
+	rate    int
+	// rows counts the rows written, the header included
+	rows int
+}
+
+func (o *xlsxUploadOutput) start() error {
+	o.file = excelize.NewFile()
+	if err := o.file.SetSheetName(o.file.GetSheetName(0), xlsxSheet); err != nil {
+		return err
+	}
+	var err error
+	if o.amount, err = o.file.NewStyle(&excelize.Style{NumFmt: xlsxAmountFormat}); err != nil {
+		return err
+	}
+	if o.rate, err = o.file.NewStyle(&excelize.Style{NumFmt: xlsxRateFormat}); err != nil {
+		return err
+	}
+	if o.stream, err = o.file.NewStreamWriter(xlsxSheet); err != nil {
+		return err
+	}
+	header := []interface{}{}
+	for _, column := range o.options.columns() {
+		header = append(header, column)
+	}
+	return o.setRow(header)
+}
+
+func (o *xlsxUploadOutput) setRow(values []interface{}) error {
+	o.rows++
+	cell, err := excelize.CoordinatesToCellName(1, o.rows)
+	if err != nil {
+		return err
+	}
+	return o.stream.SetRow(cell, values)
+}
+
+func (o *xlsxUploadOutput) amountCell(amount *money.Amount) interface{} {
+	if amount == nil {
+		return nil
+	}
+	return excelize.Cell{StyleID: o.amount, Value: amount.Float64()}
+}
+
+func (o *xlsxUploadOutput) writeRow(row TaxUploadResponseObject) error {
+	if o.file == nil {
+		if err := o.start(); err != nil {
+			return err
+		}
+	}
+	values := make([]interface{}, len(o.options.header), len(o.options.header)+len(o.options.levels)+4)
+	for i, cell := range row.record {
+		if i < len(values) {
+			values[i] = cell
+		}
+	}
+	var taxRefund *money.Amount
+	if row.Tax != nil {
+		taxRefund = &row.TaxRefund
+	}
+	values = append(values, o.amountCell(row.Tax), o.amountCell(taxRefund))
+	for _, label := range o.options.levels {
+		values = append(values, o.amountCell(levelTax(row, label)))
+	}
+	return o.setRow(append(values, row.Status, rowMessages(row)))
+}
+
+// writeSummary writes summary on the summary sheet.
+func (o *xlsxUploadOutput) writeSummary(summary TaxUploadSummary) error {
+	if _, err := o.file.NewSheet(xlsxSummarySheet); err != nil {
+		return err
+	}
+	stream, err := o.file.NewStreamWriter(xlsxSummarySheet)
+	if err != nil {
+		return err
+	}
+	for i, row := range summaryRows(summary) {
+		values := []interface{}{}
+		for _, value := range row {
+			switch value := value.(type) {
+			case money.Amount:
+				values = append(values, o.amountCell(&value))
+			case money.Rate:
+				values = append(values, excelize.Cell{StyleID: o.rate, Value: value.Float64()})
+			default:
+				values = append(values, value)
+			}
+		}
+		cell, err := excelize.CoordinatesToCellName(1, i+1)
+		if err != nil {
+			return err
+		}
+		if err := stream.SetRow(cell, values); err != nil {
+			return err
+		}
+	}
+	return stream.Flush()
+}
+
+func (o *xlsxUploadOutput) end(summary TaxUploadSummary, err error) error {
+	if o.file != nil {
+		defer o.file.Close()
+	}
+	if err != nil {
+		return err
+	}
+	if o.file == nil {
+		if err := o.start(); err != nil {
+			return problem.Wrap(err)
+		}
+	}
+	if err := o.stream.Flush(); err != nil {
+		return problem.Wrap(err)
+	}
+	if err := o.writeSummary(summary); err != nil {
+		return problem.Wrap(err)
+	}
+	o.c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="taxes.xlsx"`)
+	commit(o.c, MIMEApplicationXLSX)
+	_, err = o.file.WriteTo(o.c.Response())
+	return err
+}
+
+func (o *xlsxUploadOutput) streaming() bool {
+	return false
+}
+
+// commit sends the headers of a streamed response, unless already sent.
+func commit(c echo.Context, contentType string) {
+	if c.Response().Committed {
+		return
+	}
+	c.Response().Header().Set(echo.HeaderContentType, contentType)
+	c.Response().WriteHeader(http.StatusOK)
+}
+
+// logServerError logs err the way the error handler would, for errors that
+// can only be written into a stream already started.
+func logServerError(c echo.Context, err error) {
+	if problem.FromError(err, c).Status >= http.StatusInternalServerError {
+		c.Logger().Error(err)
+	}
+}
@@ -0,0 +1,187 @@
+package tax
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{echo.MIMEApplicationJSON, MIMEApplicationNDJSON, MIMETextCSV, MIMEApplicationXLSX}
+	testCases := []struct {
+		accept   string
+		expected string
+	}{
+		{"", echo.MIMEApplicationJSON},
+		{"*/*", echo.MIMEApplicationJSON},
+		{"text/csv", MIMETextCSV},
+		{"text/csv; charset=utf-8", MIMETextCSV},
+		{"application/x-ndjson, text/csv;q=0.9", MIMEApplicationNDJSON},
+		{"text/csv;q=0.1, application/json", echo.MIMEApplicationJSON},
+		{"text/csv;q=0.5, application/x-ndjson;q=0.5", MIMETextCSV},
+		{"text/csv;q=0, text/html", echo.MIMEApplicationJSON},
+		{"text/html, text/csv", MIMETextCSV},
+		{"text/html", echo.MIMEApplicationJSON},
+		{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", MIMEApplicationXLSX},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, negotiate(tc.accept, offers...), tc.accept)
+	}
+}
+
+func TestTaxUploadCalulateHandlerNDJSON(t *testing.T) {
+	testCases := []struct {
+		mode     string
+		content  string
+		expected string
+	}{
+		{"lenient", "id,totalIncome\nA,500000.0\nB,abc\n", `{"line":2,"id":"A","status":"ok","totalIncome":500000,"tax":29000}
+{"line":3,"id":"B","status":"error","errors":[{"field":"totalIncome","code":"invalid","message":"totalIncome must be a number"}]}
+{"summary":{"total":2,"succeeded":1,"failed":1,"totalIncome":500000,"tax":29000,"taxRefund":0,"averageEffectiveRate":0.058,"levels":[{"level":"0-150,000","rate":0,"taxpayers":0,"income":150000,"tax":0},{"level":"150,001-500,000","rate":0.1,"taxpayers":1,"income":290000,"tax":29000},{"level":"500,001-1,000,000","rate":0.15,"taxpayers":0,"income":0,"tax":0},{"level":"1,000,001-2,000,000","rate":0.2,"taxpayers":0,"income":0,"tax":0},{"level":"2,000,001 ขึ้นไป","rate":0.35,"taxpayers":0,"income":0,"tax":0}],"taxStatistics":{"min":29000,"p25":29000,"median":29000,"p75":29000,"p90":29000,"p99":29000,"max":29000}}}
+`},
+		{"strict", "id,totalIncome\nA,500000.0\nB,abc\nC,500000.0\n", `{"line":2,"id":"A","status":"ok","totalIncome":500000,"tax":29000}
+{"line":3,"id":"B","status":"error","errors":[{"field":"totalIncome","code":"invalid","message":"totalIncome must be a number"}]}
+{"error":{"type":"/problems/validation-failed","title":"Validation failed","status":422,"detail":"the request has invalid fields","instance":"/","errors":[{"field":"rows[1].totalIncome","code":"invalid","message":"totalIncome must be a number"}]}}
+`},
+		{"strict", "id,totalIncome\nA,500000.0\n\"B,1\n", `{"line":2,"id":"A","status":"ok","totalIncome":500000,"tax":29000}
+{"error":{"type":"/problems/invalid-tax-file","title":"Invalid tax file","status":400,"detail":"Invalid file format. parse error on line 3, column 6: extraneous or missing \" in quoted-field","instance":"/"}}
+`},
+	}
+
+	for _, tc := range testCases {
+		c, rec := newUploadContext(map[string]string{"taxYear": "2567", "mode": tc.mode}, tc.content)
+		c.Request().Header.Set(echo.HeaderAccept, MIMEApplicationNDJSON)
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		expectTaxRuleSnapshot(mock)
+		h := newTestHandler(db)
+
+		if assert.NoError(t, h.TaxUploadCalulateHandler(c)) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, MIMEApplicationNDJSON, rec.Header().Get(echo.HeaderContentType))
+			assert.Equal(t, tc.expected, rec.Body.String())
+		}
+	}
+}
+
+func TestTaxUploadCalulateHandlerCSV(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected string
+	}{
+		{"", "id,totalIncome,wht,tax,taxRefund,status,errors\n" +
+			"A,500000.0,30000.0,0.00,1000.00,ok,\n" +
+			"B,-1.0,,,,error,totalIncome must not be negative\n"},
+		{"levels=true&bom=true", "\ufeffid,totalIncome,wht,tax,taxRefund,\"0-150,000\",\"150,001-500,000\",\"500,001-1,000,000\",\"1,000,001-2,000,000\",\"2,000,001 ขึ้นไป\",status,errors\n" +
+			"A,500000.0,30000.0,0.00,1000.00,0.00,29000.00,0.00,0.00,0.00,ok,\n" +
+			"B,-1.0,,,,,,,,,error,totalIncome must not be negative\n"},
+		{"summary=true", "id,totalIncome,wht,tax,taxRefund,status,errors\n" +
+			"A,500000.0,30000.0,0.00,1000.00,ok,\n" +
+			"B,-1.0,,,,error,totalIncome must not be negative\n" +
+			"\n" +
+			"total,2\nsucceeded,1\nfailed,1\ntotalIncome,500000.00\ntax,0.00\ntaxRefund,1000.00\naverageEffectiveRate,0.058\n" +
+			"taxStatistics.min,0.00\ntaxStatistics.p25,0.00\ntaxStatistics.median,0.00\ntaxStatistics.p75,0.00\ntaxStatistics.p90,0.00\ntaxStatistics.p99,0.00\ntaxStatistics.max,0.00\n" +
+			"\n" +
+			"level,rate,taxpayers,income,tax\n" +
+			"\"0-150,000\",0,0,150000.00,0.00\n" +
+			"\"150,001-500,000\",0.1,1,290000.00,29000.00\n" +
+			"\"500,001-1,000,000\",0.15,0,0.00,0.00\n" +
+			"\"1,000,001-2,000,000\",0.2,0,0.00,0.00\n" +
+			"\"2,000,001 ขึ้นไป\",0.35,0,0.00,0.00\n"},
+	}
+
+	for _, tc := range testCases {
+		c, rec := newUploadContext(map[string]string{"taxYear": "2567", "mode": "lenient"}, "id,totalIncome,wht\nA,500000.0,30000.0\nB,-1.0,\n")
+		c.Request().Header.Set(echo.HeaderAccept, MIMETextCSV)
+		c.Request().URL.RawQuery = tc.query
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		expectTaxRuleSnapshot(mock)
+		h := newTestHandler(db)
+
+		if assert.NoError(t, h.TaxUploadCalulateHandler(c)) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, MIMETextCSV, rec.Header().Get(echo.HeaderContentType))
+			assert.Equal(t, tc.expected, rec.Body.String())
+		}
+	}
+}
+
+func TestTaxUploadCalulateHandlerXLSXOutput(t *testing.T) {
+	// Arrange
+	c, rec := newUploadContext(map[string]string{"taxYear": "2567", "mode": "lenient"}, "id,totalIncome,wht\nA,500000.0,30000.0\nB,-1.0,\n")
+	c.Request().Header.Set(echo.HeaderAccept, MIMEApplicationXLSX)
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	expectTaxRuleSnapshot(mock)
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.TaxUploadCalulateHandler(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, MIMEApplicationXLSX, rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, `attachment; filename="taxes.xlsx"`, rec.Header().Get(echo.HeaderContentDisposition))
+		f, err := excelize.OpenReader(rec.Body)
+		if assert.NoError(t, err) {
+			rows, err := f.GetRows(xlsxSheet, excelize.Options{RawCellValue: true})
+			assert.NoError(t, err)
+			assert.Equal(t, [][]string{
+				{"id", "totalIncome", "wht", "tax", "taxRefund", "status", "errors"},
+				{"A", "500000.0", "30000.0", "0", "1000", "ok"},
+				{"B", "-1.0", "", "", "", "error", "totalIncome must not be negative"},
+			}, rows)
+			taxRefund, err := f.GetCellValue(xlsxSheet, "E2")
+			assert.NoError(t, err)
+			assert.Equal(t, "1,000.00", taxRefund)
+			summary, err := f.GetRows(xlsxSummarySheet, excelize.Options{RawCellValue: true})
+			assert.NoError(t, err)
+			assert.Equal(t, [][]string{
+				{"total", "2"}, {"succeeded", "1"}, {"failed", "1"},
+				{"totalIncome", "500000"}, {"tax", "0"}, {"taxRefund", "1000"}, {"averageEffectiveRate", "0.058"},
+				{"taxStatistics.min", "0"}, {"taxStatistics.p25", "0"}, {"taxStatistics.median", "0"}, {"taxStatistics.p75", "0"},
+				{"taxStatistics.p90", "0"}, {"taxStatistics.p99", "0"}, {"taxStatistics.max", "0"},
+				nil,
+				{"level", "rate", "taxpayers", "income", "tax"},
+				{"0-150,000", "0", "0", "150000", "0"},
+				{"150,001-500,000", "0.1", "1", "290000", "29000"},
+				{"500,001-1,000,000", "0.15", "0", "0", "0"},
+				{"1,000,001-2,000,000", "0.2", "0", "0", "0"},
+				{"2,000,001 ขึ้นไป", "0.35", "0", "0", "0"},
+			}, summary)
+			rate, err := f.GetCellValue(xlsxSummarySheet, "B7")
+			assert.NoError(t, err)
+			assert.Equal(t, "5.80%", rate)
+		}
+	}
+}
+
+func TestErrorTaxUploadCalulateHandlerInvalidOutputOption(t *testing.T) {
+	// Arrange
+	c, _ := newUploadContext(map[string]string{"taxYear": "2567"}, "totalIncome\n500000.0\n")
+	c.Request().URL.RawQuery = "bom=maybe"
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	expectTaxRuleSnapshot(mock)
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, problem.New(problem.BadRequest, "bom must be true or false"), h.TaxUploadCalulateHandler(c))
+}
+
+func TestErrorTaxUploadCalulateHandlerStreamBeforeFirstRow(t *testing.T) {
+	// Arrange
+	c, _ := newUploadContext(map[string]string{"taxYear": "2567"}, "id,totalIncome\n")
+	c.Request().Header.Set(echo.HeaderAccept, MIMEApplicationNDJSON)
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	expectTaxRuleSnapshot(mock)
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Error(t, h.TaxUploadCalulateHandler(c))
+	assert.False(t, c.Response().Committed)
+}
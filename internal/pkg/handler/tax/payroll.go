@@ -0,0 +1,123 @@
+package tax
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
+	"github.com/labstack/echo/v4"
+)
+
+type Bonus struct {
+	Month  int          `json:"month"`
+	Amount money.Amount `json:"amount"`
+}
+
+// PayrollRequestObject describes an employee's salary for the tax year.
+// StartMonth is the month a mid-year joiner is first paid, January when unset.
+type PayrollRequestObject struct {
+	TaxYear       int          `json:"taxYear,omitempty"`
+	MonthlySalary money.Amount `json:"monthlySalary"`
+	StartMonth    int          `json:"startMonth,omitempty"`
+	Bonuses       []Bonus      `json:"bonuses"`
+	Allowances    []Allowance  `json:"allowances"`
+}
+
+type PayrollMonth struct {
+	Month  int          `json:"month"`
+	Salary money.Amount `json:"salary"`
+	Bonus  money.Amount `json:"bonus"`
+	Wht    money.Amount `json:"wht"`
+}
+
+type PayrollResponseObject struct {
+	AnnualIncome money.Amount      `json:"annualIncome"`
+	AnnualTax    money.Amount      `json:"annualTax"`
+	Schedule     []PayrollMonth    `json:"schedule"`
+	Calculation  TaxResponseObject `json:"calculation"`
+}
+
+func (h handler) TaxPayrollHandler(c echo.Context) error {
+	payrollRequestObject := PayrollRequestObject{}
+	if err := c.Bind(&payrollRequestObject); err != nil {
+		return problem.Bind(err)
+	}
+	rule, err := h.taxRule(payrollRequestObject.TaxYear)
+	if err != nil {
+		return err
+	}
+	if errs := rule.validatePayroll(payrollRequestObject); len(errs) > 0 {
+		return validationError(errs)
+	}
+	return c.JSON(http.StatusOK, payrollSchedule(payrollRequestObject, rule))
+}
+
+// validatePayroll checks every field of the request against the rule set and
+// reports all the violations found.
+func (rule TaxRule) validatePayroll(inputData PayrollRequestObject) []problem.FieldError {
+	errs := []problem.FieldError{}
+	if inputData.MonthlySalary < 0 {
+		errs = append(errs, negativeError("monthlySalary"))
+	}
+	if inputData.StartMonth < 0 || inputData.StartMonth > 12 {
+		errs = append(errs, problem.NewFieldError("startMonth", codeInvalid, "startMonth must be between 1 and 12"))
+	}
+	for i, bonus := range inputData.Bonuses {
+		field := fmt.Sprintf("bonuses[%d].", i)
+		if bonus.Month < max(inputData.StartMonth, 1) || bonus.Month > 12 {
+			errs = append(errs, problem.NewFieldError(field+"month", codeInvalid, "month must be between startMonth and 12"))
+		}
+		if bonus.Amount < 0 {
+			errs = append(errs, negativeError(field+"amount"))
+		}
+	}
+	return append(errs, rule.validateTaxRequest(TaxRequestObject{TaxYear: inputData.TaxYear, Allowances: inputData.Allowances})...)
+}
+
+// payrollSchedule spreads the annual tax on the salary evenly over the months
+// worked, the last month taking the satang left over. A bonus month withholds
+// on top the extra annual tax the bonus brings, so the schedule always adds up
+// to the annual tax on the salary and bonuses taken as the totalIncome of
+// POST /tax/calculations with the same allowances.
+func payrollSchedule(inputData PayrollRequestObject, rule TaxRule) PayrollResponseObject {
+	startMonth := max(inputData.StartMonth, 1)
+	months := int64(13 - startMonth)
+	bonuses := map[int]money.Amount{}
+	for _, bonus := range inputData.Bonuses {
+		bonuses[bonus.Month] += bonus.Amount
+	}
+	annualTax := func(bonus money.Amount) taxResult {
+		salary := inputData.MonthlySalary*money.Amount(months) + bonus
+		return taxCalculate(TaxRequestObject{
+			TaxYear:     inputData.TaxYear,
+			TotalIncome: salary,
+			Allowances:  inputData.Allowances,
+		}, rule)
+	}
+
+	salaryTax := annualTax(0).GrossTax
+	monthlyWht := salaryTax.Div(months)
+	res := PayrollResponseObject{}
+	bonusPaid, taxBefore := money.Amount(0), salaryTax
+	for month := startMonth; month <= 12; month++ {
+		payrollMonth := PayrollMonth{Month: month, Salary: inputData.MonthlySalary, Wht: monthlyWht}
+		if month == 12 {
+			payrollMonth.Wht = salaryTax - monthlyWht*money.Amount(months-1)
+		}
+		if bonus, ok := bonuses[month]; ok {
+			bonusPaid += bonus
+			taxAfter := annualTax(bonusPaid).GrossTax
+			payrollMonth.Bonus = bonus
+			payrollMonth.Wht += taxAfter - taxBefore
+			taxBefore = taxAfter
+		}
+		res.Schedule = append(res.Schedule, payrollMonth)
+	}
+
+	result := annualTax(bonusPaid)
+	res.AnnualIncome = result.TotalIncome
+	res.AnnualTax = result.GrossTax
+	res.Calculation = newTaxResponse(result)
+	return res
+}
@@ -0,0 +1,88 @@
+package tax
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPayrollSchedule(t *testing.T) {
+	// Arrange
+	rule := taxRules[2567]
+	testCases := []struct {
+		inputData PayrollRequestObject
+		annualTax money.Amount
+		wht       map[int]money.Amount
+	}{
+		{PayrollRequestObject{MonthlySalary: money.New(50000)}, money.New(41000), map[int]money.Amount{
+			1: money.FromFloat(3416.67), 11: money.FromFloat(3416.67), 12: money.FromFloat(3416.63),
+		}},
+		{PayrollRequestObject{MonthlySalary: money.New(100000)}, money.New(138000), map[int]money.Amount{
+			1: money.New(11500), 12: money.New(11500),
+		}},
+		{PayrollRequestObject{MonthlySalary: money.New(50000), StartMonth: 7, Bonuses: []Bonus{{12, money.New(200000)}}}, money.New(29000), map[int]money.Amount{
+			7: money.New(1500), 11: money.New(1500), 12: money.New(21500),
+		}},
+		{PayrollRequestObject{MonthlySalary: money.New(50000), Bonuses: []Bonus{{3, money.New(100000)}}}, money.New(56000), map[int]money.Amount{
+			2: money.FromFloat(3416.67), 3: money.FromFloat(18416.67), 12: money.FromFloat(3416.63),
+		}},
+	}
+
+	for _, tc := range testCases {
+		// Act
+		actual := payrollSchedule(tc.inputData, rule)
+
+		// Assertions
+		assert.Equal(t, tc.annualTax, actual.AnnualTax)
+		assert.Equal(t, tc.annualTax, actual.Calculation.Tax)
+		assert.Equal(t, tc.annualTax, taxCalculate(TaxRequestObject{TotalIncome: actual.AnnualIncome}, rule).GrossTax)
+		assert.Len(t, actual.Schedule, 13-max(tc.inputData.StartMonth, 1))
+		total := money.Amount(0)
+		for _, month := range actual.Schedule {
+			total += month.Wht
+			if wht, ok := tc.wht[month.Month]; ok {
+				assert.Equal(t, wht, month.Wht, "month %d", month.Month)
+			}
+		}
+		assert.Equal(t, tc.annualTax, total)
+	}
+}
+
+func TestErrorTaxPayrollHandler(t *testing.T) {
+	testCases := []struct {
+		reqBody  string
+		expected error
+	}{
+		{`{"taxYear":2567,"monthlySalary":-1.0}`, validationError([]problem.FieldError{negativeError("monthlySalary")})},
+		{`{"taxYear":2567,"monthlySalary":50000.0,"startMonth":13}`, validationError([]problem.FieldError{
+			problem.NewFieldError("startMonth", codeInvalid, "startMonth must be between 1 and 12"),
+		})},
+		{`{"taxYear":2567,"monthlySalary":50000.0,"startMonth":7,"bonuses":[{"month":3,"amount":-1.0}],"allowances":[{"allowanceType":"rent","amount":1.0}]}`, validationError([]problem.FieldError{
+			problem.NewFieldError("bonuses[0].month", codeInvalid, "month must be between startMonth and 12"),
+			negativeError("bonuses[0].amount"),
+			problem.NewFieldError("allowances[0].allowanceType", codeUnknown, "unknown allowanceType 'rent'"),
+		})},
+	}
+
+	for _, tc := range testCases {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.reqBody))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		mock.ExpectQuery("SELECT lower_bound, upper_bound, rate FROM \"tax_brackets\" WHERE tax_year = $1 ORDER BY lower_bound;").WithArgs(2567).WillReturnRows(sqlmock.NewRows([]string{"lower_bound", "upper_bound", "rate"}))
+		h := newTestHandler(db)
+
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, h.TaxPayrollHandler(c))
+	}
+}
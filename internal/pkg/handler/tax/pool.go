@@ -0,0 +1,76 @@
+package tax
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// inOrder hands the items returned by next to a pool of workers calling work,
+// and passes their results to write in the order of the items. next returns
+// io.EOF after the last item. inOrder stops at the first error of next or
+// write, or when ctx is done, and returns that error. It returns once every
+// worker is done, so next and work aren't called after.
+func inOrder[T, R any](ctx context.Context, workers int, next func() (T, error), work func(T) R, write func(R) error) error {
+	type job struct {
+		item   T
+		result chan R
+	}
+	stop, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				j.result <- work(j.item)
+			}
+		}()
+	}
+
+	// pending holds the jobs handed out, in order, and bounds how far the
+	// workers may get ahead of write
+	pending := make(chan job, max(workers, 1))
+	var nextErr error
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+		for stop.Err() == nil {
+			item, err := next()
+			if err != nil {
+				if err != io.EOF {
+					nextErr = err
+				}
+				return
+			}
+			j := job{item, make(chan R, 1)}
+			select {
+			case jobs <- j:
+			case <-stop.Done():
+				return
+			}
+			pending <- j
+		}
+	}()
+
+	var err error
+	for j := range pending {
+		if err = write(<-j.result); err != nil {
+			break
+		}
+	}
+	cancel()
+	for range pending {
+	}
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	if nextErr != nil {
+		return nextErr
+	}
+	return ctx.Err()
+}
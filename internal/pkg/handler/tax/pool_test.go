@@ -0,0 +1,77 @@
+package tax
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// counter returns a next func counting from 0 to n-1.
+func counter(n int, err error) func() (int, error) {
+	i := 0
+	return func() (int, error) {
+		if i == n {
+			return 0, err
+		}
+		i++
+		return i - 1, nil
+	}
+}
+
+func TestInOrder(t *testing.T) {
+	errWrite, errNext := errors.New("write"), errors.New("next")
+	testCases := []struct {
+		workers  int
+		n        int
+		nextErr  error
+		stopAt   int
+		expected []int
+		err      error
+	}{
+		{4, 20, io.EOF, -1, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19}, nil},
+		{0, 3, io.EOF, -1, []int{0, 1, 2}, nil},
+		{4, 0, io.EOF, -1, []int{}, nil},
+		{4, 20, io.EOF, 2, []int{0, 1, 2}, errWrite},
+		{4, 3, errNext, -1, []int{0, 1, 2}, errNext},
+	}
+
+	for _, tc := range testCases {
+		written := []int{}
+		// the first items take the longest so they'd finish last if not ordered
+		work := func(i int) int {
+			time.Sleep(time.Duration(tc.n-i) * time.Millisecond)
+			return i
+		}
+		write := func(i int) error {
+			written = append(written, i)
+			if i == tc.stopAt {
+				return errWrite
+			}
+			return nil
+		}
+
+		err := inOrder(context.Background(), tc.workers, counter(tc.n, tc.nextErr), work, write)
+
+		assert.Equal(t, tc.err, err)
+		assert.Equal(t, tc.expected, written)
+	}
+}
+
+func TestInOrderCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	next := func() (int, error) {
+		calls++
+		return 0, nil
+	}
+
+	err := inOrder(ctx, 4, next, func(i int) int { return i }, func(int) error { return nil })
+
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 0, calls)
+}
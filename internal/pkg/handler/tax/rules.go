@@ -0,0 +1,147 @@
+package tax
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/kidkrub/assessment-tax/internal/pkg/db"
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+)
+
+type taxLevel struct {
+	level      string
+	tierDiff   money.Amount
+	multiplier money.Rate
+}
+
+type TaxRule struct {
+	Year       int
+	Levels     []taxLevel
+	Deductions map[string]money.Amount
+	Allowances map[string]allowanceRule
+	GroupCaps  map[string]money.Amount
+	// income other than 40(1) above the threshold is taxed at least at the
+	// minimum tax rate
+	MinimumTaxThreshold money.Amount
+	MinimumTaxRate      money.Rate
+	// DonationMode is donationModeLegacy unless set to donationModeStatutory,
+	// which caps donations at DonationRate of the income after allowances
+	DonationMode string
+	DonationRate money.Rate
+}
+
+func upperBound(baht int64) *money.Amount {
+	value := money.New(baht)
+	return &value
+}
+
+var progressiveBrackets = []db.TaxBracket{
+	{LowerBound: money.New(0), UpperBound: upperBound(150000), Rate: money.RateFromFloat(0)},
+	{LowerBound: money.New(150000), UpperBound: upperBound(500000), Rate: money.RateFromFloat(0.1)},
+	{LowerBound: money.New(500000), UpperBound: upperBound(1000000), Rate: money.RateFromFloat(0.15)},
+	{LowerBound: money.New(1000000), UpperBound: upperBound(2000000), Rate: money.RateFromFloat(0.2)},
+	{LowerBound: money.New(2000000), UpperBound: nil, Rate: money.RateFromFloat(0.35)},
+}
+
+// taxRules holds the built-in rule set of every supported tax year, keyed by
+// Buddhist Era year. Brackets stored in the database take precedence.
+var taxRules = map[int]TaxRule{
+	2566: {
+		Year:                2566,
+		Levels:              newTaxLevels(progressiveBrackets),
+		Deductions:          map[string]money.Amount{"personal": money.New(60000), "k-receipt": money.New(40000)},
+		Allowances:          newAllowanceRules(100000),
+		GroupCaps:           allowanceGroupCaps,
+		MinimumTaxThreshold: money.New(120000),
+		MinimumTaxRate:      money.RateFromFloat(0.005),
+		DonationMode:        donationModeLegacy,
+		DonationRate:        money.RateFromFloat(0.1),
+	},
+	2567: {
+		Year:                2567,
+		Levels:              newTaxLevels(progressiveBrackets),
+		Deductions:          map[string]money.Amount{"personal": money.New(60000), "k-receipt": money.New(50000)},
+		Allowances:          newAllowanceRules(300000),
+		GroupCaps:           allowanceGroupCaps,
+		MinimumTaxThreshold: money.New(120000),
+		MinimumTaxRate:      money.RateFromFloat(0.005),
+		DonationMode:        donationModeLegacy,
+		DonationRate:        money.RateFromFloat(0.1),
+	},
+	2568: {
+		Year:                2568,
+		Levels:              newTaxLevels(progressiveBrackets),
+		Deductions:          map[string]money.Amount{"personal": money.New(60000), "k-receipt": money.New(50000)},
+		Allowances:          newAllowanceRules(300000),
+		GroupCaps:           allowanceGroupCaps,
+		MinimumTaxThreshold: money.New(120000),
+		MinimumTaxRate:      money.RateFromFloat(0.005),
+		DonationMode:        donationModeLegacy,
+		DonationRate:        money.RateFromFloat(0.1),
+	},
+	2569: {
+		Year:                2569,
+		Levels:              newTaxLevels(progressiveBrackets),
+		Deductions:          map[string]money.Amount{"personal": money.New(60000), "k-receipt": money.New(50000)},
+		Allowances:          newAllowanceRules(300000),
+		GroupCaps:           allowanceGroupCaps,
+		MinimumTaxThreshold: money.New(120000),
+		MinimumTaxRate:      money.RateFromFloat(0.005),
+		DonationMode:        donationModeLegacy,
+		DonationRate:        money.RateFromFloat(0.1),
+	},
+}
+
+// TaxYears returns the tax years that have a rule set, in order. Brackets can
+// only be set for these years.
+func TaxYears() []int {
+	years := []int{}
+	for year := range taxRules {
+		years = append(years, year)
+	}
+	slices.Sort(years)
+	return years
+}
+
+// defaultTaxYear is the tax year of requests that don't give one, the tax year
+// of now. Until the rule set of a new year is added, it's the latest year that
+// has one.
+func defaultTaxYear(now time.Time) int {
+	year := now.Year() + 543
+	if _, ok := taxRules[year]; ok {
+		return year
+	}
+	years := TaxYears()
+	return years[len(years)-1]
+}
+
+// findTaxRule returns the rule set of year, which must not be 0.
+func findTaxRule(year int) (TaxRule, error) {
+	rule, ok := taxRules[year]
+	if !ok {
+		return TaxRule{}, fmt.Errorf("unsupported tax year %d", year)
+	}
+	return rule, nil
+}
+
+func newTaxLevels(brackets []db.TaxBracket) []taxLevel {
+	levels := []taxLevel{}
+	for _, bracket := range brackets {
+		levels = append(levels, newTaxLevel(bracket))
+	}
+	return levels
+}
+
+// newTaxLevel labels a bracket the way the Revenue Department table does,
+// e.g. "0-150,000", "150,001-500,000" and "2,000,001 ขึ้นไป".
+func newTaxLevel(bracket db.TaxBracket) taxLevel {
+	lower := "0"
+	if bracket.LowerBound > 0 {
+		lower = money.FormatBaht(bracket.LowerBound + money.Baht)
+	}
+	if bracket.UpperBound == nil {
+		return taxLevel{lower + " ขึ้นไป", -1, bracket.Rate}
+	}
+	return taxLevel{lower + "-" + money.FormatBaht(*bracket.UpperBound), *bracket.UpperBound - bracket.LowerBound, bracket.Rate}
+}
@@ -0,0 +1,59 @@
+package tax
+
+import (
+	"testing"
+
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadSummary(t *testing.T) {
+	// Arrange
+	levels := func(income, tax money.Amount) []TaxLevel {
+		return []TaxLevel{
+			{Level: "0-150,000", Rate: 0, Income: min(income, money.New(150000))},
+			{Level: "150,001-500,000", Rate: money.RateFromFloat(0.1), Income: max(income-money.New(150000), 0), Tax: tax},
+		}
+	}
+	summary := newUploadSummary()
+
+	// Act
+	for i := int64(1); i <= 10; i++ {
+		tax := money.New(1000 * i)
+		summary.add(money.New(150000+10000*i), tax, 0, money.Ratio(tax, money.New(150000+10000*i)), levels(money.New(150000+10000*i), tax))
+	}
+	summary.add(money.New(100000), 0, money.New(500), 0, levels(money.New(100000), 0))
+	summary.addFailed()
+	result := summary.result()
+
+	// Assert
+	assert.Equal(t, TaxUploadSummary{
+		Total:                12,
+		Succeeded:            11,
+		Failed:               1,
+		TotalIncome:          money.New(2150000),
+		Tax:                  money.New(55000),
+		TaxRefund:            money.New(500),
+		AverageEffectiveRate: money.RateFromFloat(0.023037),
+		Levels: []LevelSummary{
+			{Level: "0-150,000", Rate: 0, Taxpayers: 1, Income: money.New(1600000)},
+			{Level: "150,001-500,000", Rate: money.RateFromFloat(0.1), Taxpayers: 10, Income: money.New(550000), Tax: money.New(55000)},
+		},
+		TaxStatistics: &TaxStatistics{
+			Min:    0,
+			P25:    money.New(2000),
+			Median: money.New(5000),
+			P75:    money.New(8000),
+			P90:    money.New(9000),
+			P99:    money.New(10000),
+			Max:    money.New(10000),
+		},
+	}, result)
+}
+
+func TestUploadSummaryNoRows(t *testing.T) {
+	summary := newUploadSummary()
+	summary.addFailed()
+
+	assert.Equal(t, TaxUploadSummary{Total: 1, Failed: 1}, summary.result())
+}
@@ -1,185 +1,260 @@
 package tax
 
 import (
+	"context"
 	"database/sql"
-	"encoding/csv"
-	"math"
+	"errors"
+	"maps"
 	"net/http"
-	"strconv"
+	"time"
 
+	"github.com/kidkrub/assessment-tax/internal/pkg/config"
 	"github.com/kidkrub/assessment-tax/internal/pkg/db"
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
 	"github.com/labstack/echo/v4"
 )
 
 type Allowance struct {
-	AllowanceType string  `json:"allowanceType"`
-	Amount        float64 `json:"amount"`
+	AllowanceType string       `json:"allowanceType"`
+	Amount        money.Amount `json:"amount"`
+	// Count is the number of people claimed for per-person allowances such as child
+	Count int `json:"count,omitempty"`
+	// filer tells apart the allowances of each spouse on a combined return, as
+	// each spouse deducts allowances up to their own caps
+	filer int
 }
 
 type TaxRequestObject struct {
-	TotalIncome float64     `json:"totalIncome"`
-	Wht         float64     `json:"wht"`
-	Allowances  []Allowance `json:"allowances"`
+	TaxYear int `json:"taxYear,omitempty"`
+	// TotalIncome is salary taken without expense deduction, ignored when
+	// Incomes is given
+	TotalIncome money.Amount `json:"totalIncome"`
+	Incomes     []Income     `json:"incomes,omitempty"`
+	Wht         money.Amount `json:"wht"`
+	Allowances  []Allowance  `json:"allowances"`
+	// filerIncomes is the total income of each spouse on a combined return,
+	// which caps the allowances of that spouse
+	filerIncomes []money.Amount
 }
 
 type TaxResponseObject struct {
-	Tax       float64    `json:"tax"`
-	TaxRefund float64    `json:"taxRefund,omitempty"`
-	TaxLevels []TaxLevel `json:"taxLevel"`
+	Tax            money.Amount      `json:"tax"`
+	TaxRefund      money.Amount      `json:"taxRefund,omitempty"`
+	TaxableIncome  money.Amount      `json:"taxableIncome"`
+	Incomes        []IncomeBreakdown `json:"incomes,omitempty"`
+	NetIncome      money.Amount      `json:"netIncome"`
+	EffectiveRate  money.Rate        `json:"effectiveRate"`
+	MarginalRate   money.Rate        `json:"marginalRate"`
+	MarginalLevel  string            `json:"marginalLevel"`
+	TaxMethod      string            `json:"taxMethod"`
+	ProgressiveTax money.Amount      `json:"progressiveTax"`
+	MinimumTax     money.Amount      `json:"minimumTax"`
+	TaxLevels      []TaxLevel        `json:"taxLevel"`
+	Steps          []CalculationStep `json:"steps,omitempty"`
 }
 
 type TaxLevel struct {
-	Level string  `json:"level"`
-	Tax   float64 `json:"tax"`
+	Level  string       `json:"level"`
+	Income money.Amount `json:"income"`
+	Rate   money.Rate   `json:"rate"`
+	Tax    money.Amount `json:"tax"`
 }
 
-type TaxUploadResponseObject struct {
-	TotalIncome float64 `json:"totalIncome"`
-	Tax         float64 `json:"tax"`
-	TaxRefund   float64 `json:"taxRefund,omitempty"`
+type handler struct {
+	db           *sql.DB
+	donationMode string
+	upload       config.Upload
+	// now is the clock the default tax year is taken from
+	now func() time.Time
 }
 
-type handler struct {
-	db *sql.DB
+func New(db *sql.DB, taxConfig config.Tax, uploadConfig config.Upload) *handler {
+	return &handler{db, taxConfig.DonationMode, uploadConfig, time.Now}
 }
 
-func New(db *sql.DB) *handler {
-	return &handler{db}
+// taxYear returns year, or the default tax year when year is 0.
+func (h handler) taxYear(year int) int {
+	if year == 0 {
+		return defaultTaxYear(h.now())
+	}
+	return year
 }
 
 func (h handler) TaxCalculateHandler(c echo.Context) error {
 	taxRequestObject := TaxRequestObject{}
 	if err := c.Bind(&taxRequestObject); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "bad request body", err.Error())
+		return problem.Bind(err)
 	}
-	tax, taxLevels := taxCalculate(taxRequestObject, map[string]float64{
-		"personal":  db.GetDeductionValue(h.db, "personal"),
-		"k-receipt": db.GetDeductionValue(h.db, "k-receipt"),
-	})
-	res := TaxResponseObject{}
-	res.TaxLevels = taxLevels
-	if tax < 0 {
-		res.Tax = 0
-		res.TaxRefund = math.Abs(tax)
-	} else {
-		res.Tax = tax
+	rule, err := h.taxRule(taxRequestObject.TaxYear)
+	if err != nil {
+		return err
+	}
+	if errs := rule.validateTaxRequest(taxRequestObject); len(errs) > 0 {
+		return validationError(errs)
+	}
+	result := taxCalculate(taxRequestObject, rule)
+	res := newTaxResponse(result)
+	if c.QueryParam("explain") == "true" {
+		res.Steps = result.Steps
 	}
 	return c.JSON(http.StatusOK, res)
 }
 
-func (h handler) TaxUploadCalulateHandler(c echo.Context) error {
-	file, err := c.FormFile("taxFile")
-	if err != nil {
-		return err
+func newTaxResponse(result taxResult) TaxResponseObject {
+	res := TaxResponseObject{}
+	res.TaxableIncome = result.TaxableIncome
+	res.Incomes = result.Incomes
+	res.NetIncome = result.TotalIncome - result.GrossTax
+	res.EffectiveRate = money.Ratio(result.GrossTax, result.TotalIncome)
+	if marginal := result.marginalLevel(); marginal != nil {
+		res.MarginalRate = marginal.Rate
+		res.MarginalLevel = marginal.Level
 	}
-	if file.Filename != "taxes.csv" {
-		return echo.NewHTTPError(http.StatusBadRequest, "File name must be 'taxes.csv")
+	res.TaxMethod = result.TaxMethod
+	res.ProgressiveTax = result.ProgressiveTax
+	res.MinimumTax = result.MinimumTax
+	res.TaxLevels = result.TaxLevels
+	res.Tax, res.TaxRefund = splitTax(result.Tax)
+	return res
+}
+
+// splitTax splits the tax left after WHT into the tax to pay and the refund.
+func splitTax(tax money.Amount) (money.Amount, money.Amount) {
+	if tax < 0 {
+		return 0, -tax
 	}
-	src, err := file.Open()
+	return tax, 0
+}
+
+// taxRule returns the rule set for the given tax year with the brackets stored
+// in the database. Deductions configured by the admin only apply to the default
+// tax year, other years use their defaults.
+func (h handler) taxRule(year int) (TaxRule, error) {
+	rule, err := findTaxRule(h.taxYear(year))
 	if err != nil {
-		return err
+		return TaxRule{}, problem.New(unsupportedTaxYear, err.Error())
 	}
-	defer src.Close()
-
-	reader := csv.NewReader(src)
-	reader.FieldsPerRecord = 3
-	records, err := reader.ReadAll()
+	settings, err := h.readRuleSettings(h.db, []int{rule.Year})
 	if err != nil {
-		echo.NewHTTPError(http.StatusBadRequest, "Invalid file format.", err.Error())
-	}
-	if len(records) < 2 || records[0][0] != "totalIncome" || records[0][1] != "wht" || records[0][2] != "donation" {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid file format. The CSV file must have a header row with 'totalIncome', 'wht' and 'donation'")
-	}
-	taxes := []TaxUploadResponseObject{}
-	for _, record := range records[1:] {
-		totalIncome, _ := strconv.ParseFloat(record[0], 64)
-		wht, _ := strconv.ParseFloat(record[1], 64)
-		donation, _ := strconv.ParseFloat(record[2], 64)
-		requestObject := TaxRequestObject{totalIncome, wht, []Allowance{{"donation", donation}}}
-		tax, _ := taxCalculate(requestObject, map[string]float64{
-			"personal":  db.GetDeductionValue(h.db, "personal"),
-			"k-receipt": db.GetDeductionValue(h.db, "k-receipt"),
-		})
-		res := TaxUploadResponseObject{}
-		res.TotalIncome = totalIncome
-		if tax < 0 {
-			res.Tax = 0
-			res.TaxRefund = math.Abs(tax)
-		} else {
-			res.Tax = tax
-		}
-		taxes = append(taxes, res)
+		return TaxRule{}, err
 	}
-	return c.JSON(http.StatusOK, struct {
-		Taxes []TaxUploadResponseObject `json:"taxes"`
-	}{taxes})
+	return settings.taxRule(year)
 }
 
-func taxCalculate(inputData TaxRequestObject, maxDeductions map[string]float64) (tax float64, taxLevelsObject []TaxLevel) {
-	personalDeduct := maxDeductions["personal"]
-	maxkReceiptDeduct := maxDeductions["k-receipt"]
-	taxable := inputData.TotalIncome - personalDeduct
+// ruleSettings are what the rule sets take from the database and the config:
+// the brackets stored for each tax year and the deductions of the default tax
+// year, which is nil when they weren't read. A deduction without a row keeps
+// the default of the rule set.
+type ruleSettings struct {
+	DefaultYear  int                     `json:"defaultYear"`
+	DonationMode string                  `json:"donationMode"`
+	Brackets     map[int][]db.TaxBracket `json:"brackets"`
+	Deductions   map[string]money.Amount `json:"deductions,omitempty"`
+}
 
-	if len(inputData.Allowances) > 0 {
-		donationAmount := 0.0
-		kreceiptAmount := 0.0
-		for _, allowance := range inputData.Allowances {
-			if allowance.AllowanceType == "donation" {
-				donationAmount += allowance.Amount
-			}
-			if allowance.AllowanceType == "k-receipt" {
-				kreceiptAmount += allowance.Amount
-			}
-		}
-		if donationAmount > 100000 {
-			donationAmount = 100000
+// readRuleSettings reads the settings of the rule sets of years with q.
+func (h handler) readRuleSettings(q db.Querier, years []int) (ruleSettings, error) {
+	settings := ruleSettings{DefaultYear: h.taxYear(0), DonationMode: h.donationMode, Brackets: map[int][]db.TaxBracket{}}
+	for _, year := range years {
+		brackets, err := db.GetTaxBrackets(q, year)
+		if err != nil {
+			return settings, problem.Wrap(err)
 		}
-		if kreceiptAmount > maxkReceiptDeduct {
-			kreceiptAmount = maxkReceiptDeduct
-		}
-		taxable -= (donationAmount + kreceiptAmount)
-	}
-
-	taxLevels := []struct {
-		level      string
-		tierDiff   float64
-		multiplier float64
-	}{
-		{"0-150,000", 150000, 0},
-		{"150,001-500,000", 350000, 0.1},
-		{"500,001-1,000,000", 500000, 0.15},
-		{"1,000,001-2,000,000", 1000000, 0.2},
-		{"2,000,001 ขึ้นไป", -1, 0.35},
-	}
-	for _, taxLevel := range taxLevels {
-		if taxable < 0 {
-			taxLevelsObject = []TaxLevel{
-				{"0-150,000", 0.0},
-				{"150,001-500,000", 0.0},
-				{"500,001-1,000,000", 0.0},
-				{"1,000,001-2,000,000", 0.0},
-				{"2,000,001 ขึ้นไป", 0.0},
-			}
-			break
-		}
-		if taxable > taxLevel.tierDiff && taxLevel.tierDiff != -1 {
-			tierTax := taxLevel.tierDiff * taxLevel.multiplier
-			tax += tierTax
-			taxable -= taxLevel.tierDiff
-			taxLevelObject := TaxLevel{taxLevel.level, tierTax}
-			taxLevelsObject = append(taxLevelsObject, taxLevelObject)
+		settings.Brackets[year] = brackets
+		if year != settings.DefaultYear {
 			continue
 		}
-		if taxable == 0 {
-			taxLevelObject := TaxLevel{taxLevel.level, 0}
-			taxLevelsObject = append(taxLevelsObject, taxLevelObject)
-			continue
+		settings.Deductions = map[string]money.Amount{}
+		for _, key := range []string{"personal", "k-receipt"} {
+			value, err := db.GetDeductionValue(q, key)
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			if err != nil {
+				return settings, problem.Wrap(err)
+			}
+			settings.Deductions[key] = value
 		}
-		tierTax := taxable * taxLevel.multiplier
-		tax += tierTax
-		taxable = 0
-		taxLevelObject := TaxLevel{taxLevel.level, tierTax}
-		taxLevelsObject = append(taxLevelsObject, taxLevelObject)
 	}
-	return tax - inputData.Wht, taxLevelsObject
+	return settings, nil
+}
+
+// taxRule returns the rule set of year, the default tax year when it's 0.
+func (s ruleSettings) taxRule(year int) (TaxRule, error) {
+	if year == 0 {
+		year = s.DefaultYear
+	}
+	rule, err := findTaxRule(year)
+	if err != nil {
+		return TaxRule{}, problem.New(unsupportedTaxYear, err.Error())
+	}
+	if brackets := s.Brackets[year]; len(brackets) > 0 {
+		rule.Levels = newTaxLevels(brackets)
+	}
+	rule.DonationMode = s.DonationMode
+	if year == s.DefaultYear && s.Deductions != nil {
+		rule.Deductions = maps.Clone(rule.Deductions)
+		maps.Copy(rule.Deductions, s.Deductions)
+	}
+	return rule, nil
+}
+
+// ruleSource gives the rule set of a tax year, read now from a handler or as of
+// the start of a batch from a taxRuleSnapshot.
+type ruleSource interface {
+	taxRule(year int) (TaxRule, error)
+}
+
+// taxRuleSnapshot holds the rule sets of every tax year as of the start of a
+// batch, so every row is calculated with the same brackets and deductions even
+// when an admin changes them midway. They're read up front within one short
+// read-only transaction, which isn't kept open while the batch is calculated.
+type taxRuleSnapshot struct {
+	settings ruleSettings
+	rules    map[int]TaxRule
+}
+
+// takeTaxRuleSnapshot reads the rule sets of every tax year now.
+func (h handler) takeTaxRuleSnapshot(ctx context.Context) (*taxRuleSnapshot, error) {
+	tx, err := h.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, problem.Wrap(err)
+	}
+	defer tx.Rollback()
+	settings, err := h.readRuleSettings(tx, TaxYears())
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, problem.Wrap(err)
+	}
+	return newTaxRuleSnapshot(settings), nil
+}
+
+// newTaxRuleSnapshot returns the snapshot of the rule sets of settings, as
+// taken earlier.
+func newTaxRuleSnapshot(settings ruleSettings) *taxRuleSnapshot {
+	s := &taxRuleSnapshot{settings: settings, rules: map[int]TaxRule{}}
+	for _, year := range TaxYears() {
+		s.rules[year], _ = settings.taxRule(year)
+	}
+	return s
+}
+
+// taxYear returns year, or the default tax year of the snapshot when year is 0.
+func (s *taxRuleSnapshot) taxYear(year int) int {
+	if year == 0 {
+		return s.settings.DefaultYear
+	}
+	return year
+}
+
+// taxRule returns the rule set of year as of the snapshot.
+func (s *taxRuleSnapshot) taxRule(year int) (TaxRule, error) {
+	rule, ok := s.rules[s.taxYear(year)]
+	if !ok {
+		return rule, problem.Newf(unsupportedTaxYear, "unsupported tax year %d", s.taxYear(year))
+	}
+	return rule, nil
 }
@@ -6,192 +6,257 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/kidkrub/assessment-tax/internal/pkg/config"
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 )
 
+// testNow is the clock of the handlers under test, in the tax year 2569.
+func testNow() time.Time {
+	return time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+}
+
+// testUpload is the upload config of the handlers under test, the defaults of
+// config.Upload.
+var testUpload = config.Upload{MaxRows: 1000000, MaxBytes: 100 << 20, Workers: 4}
+
+func newTestHandler(db *sql.DB) *handler {
+	h := New(db, config.Tax{DonationMode: donationModeLegacy}, testUpload)
+	h.now = testNow
+	return h
+}
+
+func TestDefaultTaxYear(t *testing.T) {
+	testCases := []struct {
+		now      time.Time
+		expected int
+	}{
+		{time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), 2567},
+		{time.Date(2026, 12, 31, 23, 59, 59, 0, time.UTC), 2569},
+		{time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), 2569},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, defaultTaxYear(tc.now), tc.now)
+	}
+}
+
 func TestTaxCalculate(t *testing.T) {
 	// Arrange
-	maxDeductions := map[string]float64{"personal": 60000.0, "k-receipt": 50000.0}
+	baht := money.FromFloat
+	maxDeductions := map[string]money.Amount{"personal": baht(60000.0), "k-receipt": baht(50000.0)}
 	testCases := []struct {
 		inputData     TaxRequestObject
-		maxDeductions map[string]float64
+		maxDeductions map[string]money.Amount
 		expected      struct {
-			tax       float64
+			tax       money.Amount
 			taxlevels []TaxLevel
 		}
 	}{
-		{TaxRequestObject{60000.0, 0.0, nil}, maxDeductions, struct {
-			tax       float64
-			taxlevels []TaxLevel
-		}{0.0, []TaxLevel{
-			{"0-150,000", 0.0},
-			{"150,001-500,000", 0.0},
-			{"500,001-1,000,000", 0.0},
-			{"1,000,001-2,000,000", 0.0},
-			{"2,000,001 ขึ้นไป", 0.0},
-		}}},
-		{TaxRequestObject{500000.0, 0.0, nil}, maxDeductions, struct {
-			tax       float64
-			taxlevels []TaxLevel
-		}{29000.0, []TaxLevel{
-			{"0-150,000", 0.0},
-			{"150,001-500,000", 29000.0},
-			{"500,001-1,000,000", 0.0},
-			{"1,000,001-2,000,000", 0.0},
-			{"2,000,001 ขึ้นไป", 0.0},
-		}}},
-		{TaxRequestObject{560000.0, 0.0, nil}, maxDeductions, struct {
-			tax       float64
-			taxlevels []TaxLevel
-		}{35000.0, []TaxLevel{
-			{"0-150,000", 0.0},
-			{"150,001-500,000", 35000.0},
-			{"500,001-1,000,000", 0.0},
-			{"1,000,001-2,000,000", 0.0},
-			{"2,000,001 ขึ้นไป", 0.0},
-		}}},
-		{TaxRequestObject{1060000.0, 0.0, nil}, maxDeductions, struct {
-			tax       float64
-			taxlevels []TaxLevel
-		}{110000.0, []TaxLevel{
-			{"0-150,000", 0.0},
-			{"150,001-500,000", 35000.0},
-			{"500,001-1,000,000", 75000.0},
-			{"1,000,001-2,000,000", 0.0},
-			{"2,000,001 ขึ้นไป", 0.0},
-		}}},
-		{TaxRequestObject{2060000.0, 0.0, nil}, maxDeductions, struct {
-			tax       float64
-			taxlevels []TaxLevel
-		}{310000.0, []TaxLevel{
-			{"0-150,000", 0.0},
-			{"150,001-500,000", 35000.0},
-			{"500,001-1,000,000", 75000.0},
-			{"1,000,001-2,000,000", 200000.0},
-			{"2,000,001 ขึ้นไป", 0.0},
-		}}},
-		{TaxRequestObject{2060001.0, 0.0, nil}, maxDeductions, struct {
-			tax       float64
-			taxlevels []TaxLevel
-		}{310000.35, []TaxLevel{
-			{"0-150,000", 0.0},
-			{"150,001-500,000", 35000.0},
-			{"500,001-1,000,000", 75000.0},
-			{"1,000,001-2,000,000", 200000.0},
-			{"2,000,001 ขึ้นไป", 0.35},
-		}}},
-		{TaxRequestObject{150000.0, 1000.0, nil}, maxDeductions, struct {
-			tax       float64
-			taxlevels []TaxLevel
-		}{-1000.0, []TaxLevel{
-			{"0-150,000", 0.0},
-			{"150,001-500,000", 0.0},
-			{"500,001-1,000,000", 0.0},
-			{"1,000,001-2,000,000", 0.0},
-			{"2,000,001 ขึ้นไป", 0.0},
-		}}},
-		{TaxRequestObject{500000.0, 25000.0, nil}, maxDeductions, struct {
-			tax       float64
-			taxlevels []TaxLevel
-		}{4000.0, []TaxLevel{
-			{"0-150,000", 0.0},
-			{"150,001-500,000", 29000.0},
-			{"500,001-1,000,000", 0.0},
-			{"1,000,001-2,000,000", 0.0},
-			{"2,000,001 ขึ้นไป", 0.0},
-		}}},
-		{TaxRequestObject{500000.0, 29000.0, nil}, maxDeductions, struct {
-			tax       float64
-			taxlevels []TaxLevel
-		}{0.0, []TaxLevel{
-			{"0-150,000", 0.0},
-			{"150,001-500,000", 29000.0},
-			{"500,001-1,000,000", 0.0},
-			{"1,000,001-2,000,000", 0.0},
-			{"2,000,001 ขึ้นไป", 0.0},
-		}}},
-		{TaxRequestObject{500000.0, 30000.0, nil}, maxDeductions, struct {
-			tax       float64
-			taxlevels []TaxLevel
-		}{-1000.0, []TaxLevel{
-			{"0-150,000", 0.0},
-			{"150,001-500,000", 29000.0},
-			{"500,001-1,000,000", 0.0},
-			{"1,000,001-2,000,000", 0.0},
-			{"2,000,001 ขึ้นไป", 0.0},
-		}}},
-		{TaxRequestObject{500000.0, 0.0, []Allowance{{"donation", 200000.0}}}, maxDeductions, struct {
-			tax       float64
-			taxlevels []TaxLevel
-		}{19000.0, []TaxLevel{
-			{"0-150,000", 0.0},
-			{"150,001-500,000", 19000.0},
-			{"500,001-1,000,000", 0.0},
-			{"1,000,001-2,000,000", 0.0},
-			{"2,000,001 ขึ้นไป", 0.0},
-		}}},
-		{TaxRequestObject{500000.0, 0.0, []Allowance{{"donation", 100000.0}}}, maxDeductions, struct {
-			tax       float64
-			taxlevels []TaxLevel
-		}{19000.0, []TaxLevel{
-			{"0-150,000", 0.0},
-			{"150,001-500,000", 19000.0},
-			{"500,001-1,000,000", 0.0},
-			{"1,000,001-2,000,000", 0.0},
-			{"2,000,001 ขึ้นไป", 0.0},
-		}}},
-		{TaxRequestObject{500000.0, 0.0, []Allowance{{"donation", 50000.0}}}, maxDeductions, struct {
-			tax       float64
-			taxlevels []TaxLevel
-		}{24000.0, []TaxLevel{
-			{"0-150,000", 0.0},
-			{"150,001-500,000", 24000.0},
-			{"500,001-1,000,000", 0.0},
-			{"1,000,001-2,000,000", 0.0},
-			{"2,000,001 ขึ้นไป", 0.0},
-		}}},
-		{TaxRequestObject{500000.0, 0.0, []Allowance{{"k-receipt", 200000.0}, {"donation", 100000.0}}}, maxDeductions, struct {
-			tax       float64
-			taxlevels []TaxLevel
-		}{14000.0, []TaxLevel{
-			{"0-150,000", 0.0},
-			{"150,001-500,000", 14000.0},
-			{"500,001-1,000,000", 0.0},
-			{"1,000,001-2,000,000", 0.0},
-			{"2,000,001 ขึ้นไป", 0.0},
-		}}},
-		{TaxRequestObject{500000.0, 0.0, []Allowance{{"k-receipt", 50000.0}, {"donation", 100000.0}}}, maxDeductions, struct {
-			tax       float64
-			taxlevels []TaxLevel
-		}{14000.0, []TaxLevel{
-			{"0-150,000", 0.0},
-			{"150,001-500,000", 14000.0},
-			{"500,001-1,000,000", 0.0},
-			{"1,000,001-2,000,000", 0.0},
-			{"2,000,001 ขึ้นไป", 0.0},
-		}}},
-		{TaxRequestObject{500000.0, 0.0, []Allowance{{"k-receipt", 49999.0}, {"donation", 100000.0}}}, maxDeductions, struct {
-			tax       float64
-			taxlevels []TaxLevel
-		}{14000.1, []TaxLevel{
-			{"0-150,000", 0.0},
-			{"150,001-500,000", 14000.1},
-			{"500,001-1,000,000", 0.0},
-			{"1,000,001-2,000,000", 0.0},
-			{"2,000,001 ขึ้นไป", 0.0},
+		{TaxRequestObject{TotalIncome: baht(60000.0), Wht: baht(0.0), Allowances: nil}, maxDeductions, struct {
+			tax       money.Amount
+			taxlevels []TaxLevel
+		}{baht(0.0), []TaxLevel{
+			{"0-150,000", baht(0), money.RateFromFloat(0), baht(0.0)},
+			{"150,001-500,000", baht(0), money.RateFromFloat(0.1), baht(0.0)},
+			{"500,001-1,000,000", baht(0), money.RateFromFloat(0.15), baht(0.0)},
+			{"1,000,001-2,000,000", baht(0), money.RateFromFloat(0.2), baht(0.0)},
+			{"2,000,001 ขึ้นไป", baht(0), money.RateFromFloat(0.35), baht(0.0)},
+		}}},
+		{TaxRequestObject{TotalIncome: baht(500000.0), Wht: baht(0.0), Allowances: nil}, maxDeductions, struct {
+			tax       money.Amount
+			taxlevels []TaxLevel
+		}{baht(29000.0), []TaxLevel{
+			{"0-150,000", baht(150000), money.RateFromFloat(0), baht(0.0)},
+			{"150,001-500,000", baht(290000), money.RateFromFloat(0.1), baht(29000.0)},
+			{"500,001-1,000,000", baht(0), money.RateFromFloat(0.15), baht(0.0)},
+			{"1,000,001-2,000,000", baht(0), money.RateFromFloat(0.2), baht(0.0)},
+			{"2,000,001 ขึ้นไป", baht(0), money.RateFromFloat(0.35), baht(0.0)},
+		}}},
+		{TaxRequestObject{TotalIncome: baht(560000.0), Wht: baht(0.0), Allowances: nil}, maxDeductions, struct {
+			tax       money.Amount
+			taxlevels []TaxLevel
+		}{baht(35000.0), []TaxLevel{
+			{"0-150,000", baht(150000), money.RateFromFloat(0), baht(0.0)},
+			{"150,001-500,000", baht(350000), money.RateFromFloat(0.1), baht(35000.0)},
+			{"500,001-1,000,000", baht(0), money.RateFromFloat(0.15), baht(0.0)},
+			{"1,000,001-2,000,000", baht(0), money.RateFromFloat(0.2), baht(0.0)},
+			{"2,000,001 ขึ้นไป", baht(0), money.RateFromFloat(0.35), baht(0.0)},
+		}}},
+		{TaxRequestObject{TotalIncome: baht(1060000.0), Wht: baht(0.0), Allowances: nil}, maxDeductions, struct {
+			tax       money.Amount
+			taxlevels []TaxLevel
+		}{baht(110000.0), []TaxLevel{
+			{"0-150,000", baht(150000), money.RateFromFloat(0), baht(0.0)},
+			{"150,001-500,000", baht(350000), money.RateFromFloat(0.1), baht(35000.0)},
+			{"500,001-1,000,000", baht(500000), money.RateFromFloat(0.15), baht(75000.0)},
+			{"1,000,001-2,000,000", baht(0), money.RateFromFloat(0.2), baht(0.0)},
+			{"2,000,001 ขึ้นไป", baht(0), money.RateFromFloat(0.35), baht(0.0)},
+		}}},
+		{TaxRequestObject{TotalIncome: baht(2060000.0), Wht: baht(0.0), Allowances: nil}, maxDeductions, struct {
+			tax       money.Amount
+			taxlevels []TaxLevel
+		}{baht(310000.0), []TaxLevel{
+			{"0-150,000", baht(150000), money.RateFromFloat(0), baht(0.0)},
+			{"150,001-500,000", baht(350000), money.RateFromFloat(0.1), baht(35000.0)},
+			{"500,001-1,000,000", baht(500000), money.RateFromFloat(0.15), baht(75000.0)},
+			{"1,000,001-2,000,000", baht(1000000), money.RateFromFloat(0.2), baht(200000.0)},
+			{"2,000,001 ขึ้นไป", baht(0), money.RateFromFloat(0.35), baht(0.0)},
+		}}},
+		{TaxRequestObject{TotalIncome: baht(2060001.0), Wht: baht(0.0), Allowances: nil}, maxDeductions, struct {
+			tax       money.Amount
+			taxlevels []TaxLevel
+		}{baht(310000.0), []TaxLevel{
+			{"0-150,000", baht(150000), money.RateFromFloat(0), baht(0.0)},
+			{"150,001-500,000", baht(350000), money.RateFromFloat(0.1), baht(35000.0)},
+			{"500,001-1,000,000", baht(500000), money.RateFromFloat(0.15), baht(75000.0)},
+			{"1,000,001-2,000,000", baht(1000000), money.RateFromFloat(0.2), baht(200000.0)},
+			{"2,000,001 ขึ้นไป", baht(1), money.RateFromFloat(0.35), baht(0.35)},
+		}}},
+		{TaxRequestObject{TotalIncome: baht(150000.0), Wht: baht(1000.0), Allowances: nil}, maxDeductions, struct {
+			tax       money.Amount
+			taxlevels []TaxLevel
+		}{baht(-1000.0), []TaxLevel{
+			{"0-150,000", baht(90000), money.RateFromFloat(0), baht(0.0)},
+			{"150,001-500,000", baht(0), money.RateFromFloat(0.1), baht(0.0)},
+			{"500,001-1,000,000", baht(0), money.RateFromFloat(0.15), baht(0.0)},
+			{"1,000,001-2,000,000", baht(0), money.RateFromFloat(0.2), baht(0.0)},
+			{"2,000,001 ขึ้นไป", baht(0), money.RateFromFloat(0.35), baht(0.0)},
+		}}},
+		{TaxRequestObject{TotalIncome: baht(500000.0), Wht: baht(25000.0), Allowances: nil}, maxDeductions, struct {
+			tax       money.Amount
+			taxlevels []TaxLevel
+		}{baht(4000.0), []TaxLevel{
+			{"0-150,000", baht(150000), money.RateFromFloat(0), baht(0.0)},
+			{"150,001-500,000", baht(290000), money.RateFromFloat(0.1), baht(29000.0)},
+			{"500,001-1,000,000", baht(0), money.RateFromFloat(0.15), baht(0.0)},
+			{"1,000,001-2,000,000", baht(0), money.RateFromFloat(0.2), baht(0.0)},
+			{"2,000,001 ขึ้นไป", baht(0), money.RateFromFloat(0.35), baht(0.0)},
+		}}},
+		{TaxRequestObject{TotalIncome: baht(500000.0), Wht: baht(29000.0), Allowances: nil}, maxDeductions, struct {
+			tax       money.Amount
+			taxlevels []TaxLevel
+		}{baht(0.0), []TaxLevel{
+			{"0-150,000", baht(150000), money.RateFromFloat(0), baht(0.0)},
+			{"150,001-500,000", baht(290000), money.RateFromFloat(0.1), baht(29000.0)},
+			{"500,001-1,000,000", baht(0), money.RateFromFloat(0.15), baht(0.0)},
+			{"1,000,001-2,000,000", baht(0), money.RateFromFloat(0.2), baht(0.0)},
+			{"2,000,001 ขึ้นไป", baht(0), money.RateFromFloat(0.35), baht(0.0)},
+		}}},
+		{TaxRequestObject{TotalIncome: baht(500000.0), Wht: baht(30000.0), Allowances: nil}, maxDeductions, struct {
+			tax       money.Amount
+			taxlevels []TaxLevel
+		}{baht(-1000.0), []TaxLevel{
+			{"0-150,000", baht(150000), money.RateFromFloat(0), baht(0.0)},
+			{"150,001-500,000", baht(290000), money.RateFromFloat(0.1), baht(29000.0)},
+			{"500,001-1,000,000", baht(0), money.RateFromFloat(0.15), baht(0.0)},
+			{"1,000,001-2,000,000", baht(0), money.RateFromFloat(0.2), baht(0.0)},
+			{"2,000,001 ขึ้นไป", baht(0), money.RateFromFloat(0.35), baht(0.0)},
+		}}},
+		{TaxRequestObject{TotalIncome: baht(500000.0), Wht: baht(0.0), Allowances: []Allowance{{AllowanceType: "donation", Amount: baht(200000.0)}}}, maxDeductions, struct {
+			tax       money.Amount
+			taxlevels []TaxLevel
+		}{baht(19000.0), []TaxLevel{
+			{"0-150,000", baht(150000), money.RateFromFloat(0), baht(0.0)},
+			{"150,001-500,000", baht(190000), money.RateFromFloat(0.1), baht(19000.0)},
+			{"500,001-1,000,000", baht(0), money.RateFromFloat(0.15), baht(0.0)},
+			{"1,000,001-2,000,000", baht(0), money.RateFromFloat(0.2), baht(0.0)},
+			{"2,000,001 ขึ้นไป", baht(0), money.RateFromFloat(0.35), baht(0.0)},
+		}}},
+		{TaxRequestObject{TotalIncome: baht(500000.0), Wht: baht(0.0), Allowances: []Allowance{{AllowanceType: "donation", Amount: baht(100000.0)}}}, maxDeductions, struct {
+			tax       money.Amount
+			taxlevels []TaxLevel
+		}{baht(19000.0), []TaxLevel{
+			{"0-150,000", baht(150000), money.RateFromFloat(0), baht(0.0)},
+			{"150,001-500,000", baht(190000), money.RateFromFloat(0.1), baht(19000.0)},
+			{"500,001-1,000,000", baht(0), money.RateFromFloat(0.15), baht(0.0)},
+			{"1,000,001-2,000,000", baht(0), money.RateFromFloat(0.2), baht(0.0)},
+			{"2,000,001 ขึ้นไป", baht(0), money.RateFromFloat(0.35), baht(0.0)},
+		}}},
+		{TaxRequestObject{TotalIncome: baht(500000.0), Wht: baht(0.0), Allowances: []Allowance{{AllowanceType: "donation", Amount: baht(50000.0)}}}, maxDeductions, struct {
+			tax       money.Amount
+			taxlevels []TaxLevel
+		}{baht(24000.0), []TaxLevel{
+			{"0-150,000", baht(150000), money.RateFromFloat(0), baht(0.0)},
+			{"150,001-500,000", baht(240000), money.RateFromFloat(0.1), baht(24000.0)},
+			{"500,001-1,000,000", baht(0), money.RateFromFloat(0.15), baht(0.0)},
+			{"1,000,001-2,000,000", baht(0), money.RateFromFloat(0.2), baht(0.0)},
+			{"2,000,001 ขึ้นไป", baht(0), money.RateFromFloat(0.35), baht(0.0)},
+		}}},
+		{TaxRequestObject{TotalIncome: baht(500000.0), Wht: baht(0.0), Allowances: []Allowance{{AllowanceType: "k-receipt", Amount: baht(200000.0)}, {AllowanceType: "donation", Amount: baht(100000.0)}}}, maxDeductions, struct {
+			tax       money.Amount
+			taxlevels []TaxLevel
+		}{baht(14000.0), []TaxLevel{
+			{"0-150,000", baht(150000), money.RateFromFloat(0), baht(0.0)},
+			{"150,001-500,000", baht(140000), money.RateFromFloat(0.1), baht(14000.0)},
+			{"500,001-1,000,000", baht(0), money.RateFromFloat(0.15), baht(0.0)},
+			{"1,000,001-2,000,000", baht(0), money.RateFromFloat(0.2), baht(0.0)},
+			{"2,000,001 ขึ้นไป", baht(0), money.RateFromFloat(0.35), baht(0.0)},
+		}}},
+		{TaxRequestObject{TotalIncome: baht(500000.0), Wht: baht(0.0), Allowances: []Allowance{{AllowanceType: "k-receipt", Amount: baht(50000.0)}, {AllowanceType: "donation", Amount: baht(100000.0)}}}, maxDeductions, struct {
+			tax       money.Amount
+			taxlevels []TaxLevel
+		}{baht(14000.0), []TaxLevel{
+			{"0-150,000", baht(150000), money.RateFromFloat(0), baht(0.0)},
+			{"150,001-500,000", baht(140000), money.RateFromFloat(0.1), baht(14000.0)},
+			{"500,001-1,000,000", baht(0), money.RateFromFloat(0.15), baht(0.0)},
+			{"1,000,001-2,000,000", baht(0), money.RateFromFloat(0.2), baht(0.0)},
+			{"2,000,001 ขึ้นไป", baht(0), money.RateFromFloat(0.35), baht(0.0)},
+		}}},
+		{TaxRequestObject{TotalIncome: baht(500000.0), Wht: baht(0.0), Allowances: []Allowance{{AllowanceType: "k-receipt", Amount: baht(49999.0)}, {AllowanceType: "donation", Amount: baht(100000.0)}}}, maxDeductions, struct {
+			tax       money.Amount
+			taxlevels []TaxLevel
+		}{baht(14000.0), []TaxLevel{
+			{"0-150,000", baht(150000), money.RateFromFloat(0), baht(0.0)},
+			{"150,001-500,000", baht(140001), money.RateFromFloat(0.1), baht(14000.1)},
+			{"500,001-1,000,000", baht(0), money.RateFromFloat(0.15), baht(0.0)},
+			{"1,000,001-2,000,000", baht(0), money.RateFromFloat(0.2), baht(0.0)},
+			{"2,000,001 ขึ้นไป", baht(0), money.RateFromFloat(0.35), baht(0.0)},
 		}}},
 	}
 
 	// Act & Assert
 	for _, tc := range testCases {
 		// Act
-		actualTax, actualLevels := taxCalculate(tc.inputData, tc.maxDeductions)
+		rule := taxRules[2567]
+		rule.Deductions = tc.maxDeductions
+		actual := taxCalculate(tc.inputData, rule)
 		// Assert
-		assert.Equal(t, tc.expected.tax, actualTax, "tax calculation is incorrect for %.2f case", tc.inputData.TotalIncome)
-		assert.Equal(t, tc.expected.taxlevels, actualLevels, "levels calculation is incorrect for %.2f case", tc.inputData.TotalIncome)
+		assert.Equal(t, tc.expected.tax, actual.Tax, "tax calculation is incorrect for %.2f case", tc.inputData.TotalIncome.Float64())
+		assert.Equal(t, tc.expected.taxlevels, actual.TaxLevels, "levels calculation is incorrect for %.2f case", tc.inputData.TotalIncome.Float64())
+	}
+}
+
+func TestTaxCalculateMinimumTax(t *testing.T) {
+	// Arrange
+	expense := money.New(1900000)
+	testCases := []struct {
+		incomes        []Income
+		method         string
+		progressiveTax money.Amount
+		minimumTax     money.Amount
+		tax            money.Amount
+	}{
+		{[]Income{{Category: "40(1)", Amount: money.New(1000000)}}, taxMethodProgressive, money.New(86000), 0, money.New(86000)},
+		{[]Income{{Category: "40(8)", Amount: money.New(120000)}}, taxMethodProgressive, 0, 0, 0},
+		{[]Income{{Category: "40(8)", Amount: money.New(1000000)}}, taxMethodProgressive, money.New(19000), money.New(5000), money.New(19000)},
+		{[]Income{{Category: "40(8)", Amount: money.New(2000000), Expense: &expense}}, taxMethodMinimum, 0, money.New(10000), money.New(10000)},
+	}
+
+	for _, tc := range testCases {
+		// Act
+		actual := taxCalculate(TaxRequestObject{Incomes: tc.incomes}, taxRules[2567])
+
+		// Assertions
+		assert.Equal(t, tc.method, actual.TaxMethod)
+		assert.Equal(t, tc.progressiveTax, actual.ProgressiveTax)
+		assert.Equal(t, tc.minimumTax, actual.MinimumTax)
+		assert.Equal(t, tc.tax, actual.Tax)
 	}
 }
 
@@ -202,10 +267,12 @@ func TestTaxCalculateHandler(t *testing.T) {
 		if err != nil {
 			return nil, err
 		}
+		bRows := sqlmock.NewRows([]string{"lower_bound", "upper_bound", "rate"})
 		pRow := sqlmock.NewRows([]string{"maxAmount"}).AddRow(60000.0)
 		kRow := sqlmock.NewRows([]string{"maxAmount"}).AddRow(50000.0)
-		mock.ExpectQuery("SELECT 'maxAmount' FROM \"dedictions\" WHERE \"name\" = $1;").WithArgs("personal").WillReturnRows(pRow)
-		mock.ExpectQuery("SELECT 'maxAmount' FROM \"dedictions\" WHERE \"name\" = $1;").WithArgs("k-receipt").WillReturnRows(kRow)
+		mock.ExpectQuery("SELECT lower_bound, upper_bound, rate FROM \"tax_brackets\" WHERE tax_year = $1 ORDER BY lower_bound;").WithArgs(sqlmock.AnyArg()).WillReturnRows(bRows)
+		mock.ExpectQuery("SELECT maxAmount FROM \"deductions\" WHERE \"name\" = $1;").WithArgs("personal").WillReturnRows(pRow)
+		mock.ExpectQuery("SELECT maxAmount FROM \"deductions\" WHERE \"name\" = $1;").WithArgs("k-receipt").WillReturnRows(kRow)
 		return db, err
 	}
 	testCases := []struct {
@@ -213,22 +280,23 @@ func TestTaxCalculateHandler(t *testing.T) {
 		sqlFn           func() (*sql.DB, error)
 		expectedResBody string
 	}{
-		{`{"totalIncome":60000.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":0.0,"taxLevel":[{"level":"0-150,000","tax":0.0},{"level":"150,001-500,000","tax":0.0},{"level":"500,001-1,000,000","tax":0.0},{"level":"1,000,001-2,000,000","tax":0.0},{"level":"2,000,001 ขึ้นไป","tax":0.0}]}`},
-		{`{"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":29000.0,"taxLevel":[{"level":"0-150,000","tax":0.0},{"level":"150,001-500,000","tax":29000.0},{"level":"500,001-1,000,000","tax":0.0},{"level":"1,000,001-2,000,000","tax":0.0},{"level":"2,000,001 ขึ้นไป","tax":0.0}]}`},
-		{`{"totalIncome":560000.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":35000.0,"taxLevel":[{"level":"0-150,000","tax":0.0},{"level":"150,001-500,000","tax":35000.0},{"level":"500,001-1,000,000","tax":0.0},{"level":"1,000,001-2,000,000","tax":0.0},{"level":"2,000,001 ขึ้นไป","tax":0.0}]}`},
-		{`{"totalIncome":1060000.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":110000.0,"taxLevel":[{"level":"0-150,000","tax":0.0},{"level":"150,001-500,000","tax":35000.0},{"level":"500,001-1,000,000","tax":75000.0},{"level":"1,000,001-2,000,000","tax":0.0},{"level":"2,000,001 ขึ้นไป","tax":0.0}]}`},
-		{`{"totalIncome":2060000.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":310000.0,"taxLevel":[{"level":"0-150,000","tax":0.0},{"level":"150,001-500,000","tax":35000.0},{"level":"500,001-1,000,000","tax":75000.0},{"level":"1,000,001-2,000,000","tax":200000.0},{"level":"2,000,001 ขึ้นไป","tax":0.0}]}`},
-		{`{"totalIncome":2060001.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":310000.35,"taxLevel":[{"level":"0-150,000","tax":0.0},{"level":"150,001-500,000","tax":35000.0},{"level":"500,001-1,000,000","tax":75000.0},{"level":"1,000,001-2,000,000","tax":200000.0},{"level":"2,000,001 ขึ้นไป","tax":0.35}]}`},
-		{`{"totalIncome":150000.0,"wht":1000.0,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":0.0,"taxRefund":1000.0,"taxLevel":[{"level":"0-150,000","tax":0.0},{"level":"150,001-500,000","tax":0.0},{"level":"500,001-1,000,000","tax":0.0},{"level":"1,000,001-2,000,000","tax":0.0},{"level":"2,000,001 ขึ้นไป","tax":0.0}]}`},
-		{`{"totalIncome":500000.0,"wht":25000.0,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":4000.0,"taxLevel":[{"level":"0-150,000","tax":0.0},{"level":"150,001-500,000","tax":29000.0},{"level":"500,001-1,000,000","tax":0.0},{"level":"1,000,001-2,000,000","tax":0.0},{"level":"2,000,001 ขึ้นไป","tax":0.0}]}`},
-		{`{"totalIncome":500000.0,"wht":29000.0,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":0.0,"taxLevel":[{"level":"0-150,000","tax":0.0},{"level":"150,001-500,000","tax":29000.0},{"level":"500,001-1,000,000","tax":0.0},{"level":"1,000,001-2,000,000","tax":0.0},{"level":"2,000,001 ขึ้นไป","tax":0.0}]}`},
-		{`{"totalIncome":500000.0,"wht":30000.0,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":0.0,"taxRefund":1000.0,"taxLevel":[{"level":"0-150,000","tax":0.0},{"level":"150,001-500,000","tax":29000.0},{"level":"500,001-1,000,000","tax":0.0},{"level":"1,000,001-2,000,000","tax":0.0},{"level":"2,000,001 ขึ้นไป","tax":0.0}]}`},
-		{`{"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":200000.0}]}`, sqlFunc, `{"tax":19000.0,"taxLevel":[{"level":"0-150,000","tax":0.0},{"level":"150,001-500,000","tax":19000.0},{"level":"500,001-1,000,000","tax":0.0},{"level":"1,000,001-2,000,000","tax":0.0},{"level":"2,000,001 ขึ้นไป","tax":0.0}]}`},
-		{`{"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":100000.0}]}`, sqlFunc, `{"tax":19000.0,"taxLevel":[{"level":"0-150,000","tax":0.0},{"level":"150,001-500,000","tax":19000.0},{"level":"500,001-1,000,000","tax":0.0},{"level":"1,000,001-2,000,000","tax":0.0},{"level":"2,000,001 ขึ้นไป","tax":0.0}]}`},
-		{`{"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":50000.0}]}`, sqlFunc, `{"tax":24000.0,"taxLevel":[{"level":"0-150,000","tax":0.0},{"level":"150,001-500,000","tax":24000.0},{"level":"500,001-1,000,000","tax":0.0},{"level":"1,000,001-2,000,000","tax":0.0},{"level":"2,000,001 ขึ้นไป","tax":0.0}]}`},
-		{`{"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"k-receipt","amount":200000.0},{"allowanceType":"donation","amount":100000.0}]}`, sqlFunc, `{"tax":14000.0,"taxLevel":[{"level":"0-150,000","tax":0.0},{"level":"150,001-500,000","tax":14000.0},{"level":"500,001-1,000,000","tax":0.0},{"level":"1,000,001-2,000,000","tax":0.0},{"level":"2,000,001 ขึ้นไป","tax":0.0}]}`},
-		{`{"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"k-receipt","amount":50000.0},{"allowanceType":"donation","amount":100000.0}]}`, sqlFunc, `{"tax":14000.0,"taxLevel":[{"level":"0-150,000","tax":0.0},{"level":"150,001-500,000","tax":14000.0},{"level":"500,001-1,000,000","tax":0.0},{"level":"1,000,001-2,000,000","tax":0.0},{"level":"2,000,001 ขึ้นไป","tax":0.0}]}`},
-		{`{"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"k-receipt","amount":49999.0},{"allowanceType":"donation","amount":100000.0}]}`, sqlFunc, `{"tax":14000.1,"taxLevel":[{"level":"0-150,000","tax":0.0},{"level":"150,001-500,000","tax":14000.1},{"level":"500,001-1,000,000","tax":0.0},{"level":"1,000,001-2,000,000","tax":0.0},{"level":"2,000,001 ขึ้นไป","tax":0.0}]}`},
+		{`{"totalIncome":60000.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":0.0,"taxableIncome":0,"netIncome":60000,"effectiveRate":0,"marginalRate":0,"marginalLevel":"0-150,000","taxMethod":"progressive","progressiveTax":0,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":0,"rate":0,"tax":0},{"level":"150,001-500,000","income":0,"rate":0.1,"tax":0},{"level":"500,001-1,000,000","income":0,"rate":0.15,"tax":0},{"level":"1,000,001-2,000,000","income":0,"rate":0.2,"tax":0},{"level":"2,000,001 ขึ้นไป","income":0,"rate":0.35,"tax":0}]}`},
+		{`{"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":29000.0,"taxableIncome":440000,"netIncome":471000,"effectiveRate":0.058,"marginalRate":0.1,"marginalLevel":"150,001-500,000","taxMethod":"progressive","progressiveTax":29000,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000,"rate":0,"tax":0},{"level":"150,001-500,000","income":290000,"rate":0.1,"tax":29000},{"level":"500,001-1,000,000","income":0,"rate":0.15,"tax":0},{"level":"1,000,001-2,000,000","income":0,"rate":0.2,"tax":0},{"level":"2,000,001 ขึ้นไป","income":0,"rate":0.35,"tax":0}]}`},
+		{`{"totalIncome":560000.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":35000.0,"taxableIncome":500000,"netIncome":525000,"effectiveRate":0.0625,"marginalRate":0.1,"marginalLevel":"150,001-500,000","taxMethod":"progressive","progressiveTax":35000,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000,"rate":0,"tax":0},{"level":"150,001-500,000","income":350000,"rate":0.1,"tax":35000},{"level":"500,001-1,000,000","income":0,"rate":0.15,"tax":0},{"level":"1,000,001-2,000,000","income":0,"rate":0.2,"tax":0},{"level":"2,000,001 ขึ้นไป","income":0,"rate":0.35,"tax":0}]}`},
+		{`{"totalIncome":1060000.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":110000.0,"taxableIncome":1000000,"netIncome":950000,"effectiveRate":0.103774,"marginalRate":0.15,"marginalLevel":"500,001-1,000,000","taxMethod":"progressive","progressiveTax":110000,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000,"rate":0,"tax":0},{"level":"150,001-500,000","income":350000,"rate":0.1,"tax":35000},{"level":"500,001-1,000,000","income":500000,"rate":0.15,"tax":75000},{"level":"1,000,001-2,000,000","income":0,"rate":0.2,"tax":0},{"level":"2,000,001 ขึ้นไป","income":0,"rate":0.35,"tax":0}]}`},
+		{`{"totalIncome":2060000.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":310000.0,"taxableIncome":2000000,"netIncome":1750000,"effectiveRate":0.150485,"marginalRate":0.2,"marginalLevel":"1,000,001-2,000,000","taxMethod":"progressive","progressiveTax":310000,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000,"rate":0,"tax":0},{"level":"150,001-500,000","income":350000,"rate":0.1,"tax":35000},{"level":"500,001-1,000,000","income":500000,"rate":0.15,"tax":75000},{"level":"1,000,001-2,000,000","income":1000000,"rate":0.2,"tax":200000},{"level":"2,000,001 ขึ้นไป","income":0,"rate":0.35,"tax":0}]}`},
+		{`{"totalIncome":2060001.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":310000.0,"taxableIncome":2000001,"netIncome":1750001,"effectiveRate":0.150485,"marginalRate":0.35,"marginalLevel":"2,000,001 ขึ้นไป","taxMethod":"progressive","progressiveTax":310000,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000,"rate":0,"tax":0},{"level":"150,001-500,000","income":350000,"rate":0.1,"tax":35000},{"level":"500,001-1,000,000","income":500000,"rate":0.15,"tax":75000},{"level":"1,000,001-2,000,000","income":1000000,"rate":0.2,"tax":200000},{"level":"2,000,001 ขึ้นไป","income":1,"rate":0.35,"tax":0.35}]}`},
+		{`{"totalIncome":150000.0,"wht":1000.0,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":0.0,"taxRefund":1000.0,"taxableIncome":90000,"netIncome":150000,"effectiveRate":0,"marginalRate":0,"marginalLevel":"0-150,000","taxMethod":"progressive","progressiveTax":0,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":90000,"rate":0,"tax":0},{"level":"150,001-500,000","income":0,"rate":0.1,"tax":0},{"level":"500,001-1,000,000","income":0,"rate":0.15,"tax":0},{"level":"1,000,001-2,000,000","income":0,"rate":0.2,"tax":0},{"level":"2,000,001 ขึ้นไป","income":0,"rate":0.35,"tax":0}]}`},
+		{`{"totalIncome":500000.0,"wht":25000.0,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":4000.0,"taxableIncome":440000,"netIncome":471000,"effectiveRate":0.058,"marginalRate":0.1,"marginalLevel":"150,001-500,000","taxMethod":"progressive","progressiveTax":29000,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000,"rate":0,"tax":0},{"level":"150,001-500,000","income":290000,"rate":0.1,"tax":29000},{"level":"500,001-1,000,000","income":0,"rate":0.15,"tax":0},{"level":"1,000,001-2,000,000","income":0,"rate":0.2,"tax":0},{"level":"2,000,001 ขึ้นไป","income":0,"rate":0.35,"tax":0}]}`},
+		{`{"totalIncome":500000.0,"wht":29000.0,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":0.0,"taxableIncome":440000,"netIncome":471000,"effectiveRate":0.058,"marginalRate":0.1,"marginalLevel":"150,001-500,000","taxMethod":"progressive","progressiveTax":29000,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000,"rate":0,"tax":0},{"level":"150,001-500,000","income":290000,"rate":0.1,"tax":29000},{"level":"500,001-1,000,000","income":0,"rate":0.15,"tax":0},{"level":"1,000,001-2,000,000","income":0,"rate":0.2,"tax":0},{"level":"2,000,001 ขึ้นไป","income":0,"rate":0.35,"tax":0}]}`},
+		{`{"totalIncome":500000.0,"wht":30000.0,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":0.0,"taxRefund":1000.0,"taxableIncome":440000,"netIncome":471000,"effectiveRate":0.058,"marginalRate":0.1,"marginalLevel":"150,001-500,000","taxMethod":"progressive","progressiveTax":29000,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000,"rate":0,"tax":0},{"level":"150,001-500,000","income":290000,"rate":0.1,"tax":29000},{"level":"500,001-1,000,000","income":0,"rate":0.15,"tax":0},{"level":"1,000,001-2,000,000","income":0,"rate":0.2,"tax":0},{"level":"2,000,001 ขึ้นไป","income":0,"rate":0.35,"tax":0}]}`},
+		{`{"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":200000.0}]}`, sqlFunc, `{"tax":19000.0,"taxableIncome":340000,"netIncome":481000,"effectiveRate":0.038,"marginalRate":0.1,"marginalLevel":"150,001-500,000","taxMethod":"progressive","progressiveTax":19000,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000,"rate":0,"tax":0},{"level":"150,001-500,000","income":190000,"rate":0.1,"tax":19000},{"level":"500,001-1,000,000","income":0,"rate":0.15,"tax":0},{"level":"1,000,001-2,000,000","income":0,"rate":0.2,"tax":0},{"level":"2,000,001 ขึ้นไป","income":0,"rate":0.35,"tax":0}]}`},
+		{`{"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":100000.0}]}`, sqlFunc, `{"tax":19000.0,"taxableIncome":340000,"netIncome":481000,"effectiveRate":0.038,"marginalRate":0.1,"marginalLevel":"150,001-500,000","taxMethod":"progressive","progressiveTax":19000,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000,"rate":0,"tax":0},{"level":"150,001-500,000","income":190000,"rate":0.1,"tax":19000},{"level":"500,001-1,000,000","income":0,"rate":0.15,"tax":0},{"level":"1,000,001-2,000,000","income":0,"rate":0.2,"tax":0},{"level":"2,000,001 ขึ้นไป","income":0,"rate":0.35,"tax":0}]}`},
+		{`{"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":50000.0}]}`, sqlFunc, `{"tax":24000.0,"taxableIncome":390000,"netIncome":476000,"effectiveRate":0.048,"marginalRate":0.1,"marginalLevel":"150,001-500,000","taxMethod":"progressive","progressiveTax":24000,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000,"rate":0,"tax":0},{"level":"150,001-500,000","income":240000,"rate":0.1,"tax":24000},{"level":"500,001-1,000,000","income":0,"rate":0.15,"tax":0},{"level":"1,000,001-2,000,000","income":0,"rate":0.2,"tax":0},{"level":"2,000,001 ขึ้นไป","income":0,"rate":0.35,"tax":0}]}`},
+		{`{"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"k-receipt","amount":200000.0},{"allowanceType":"donation","amount":100000.0}]}`, sqlFunc, `{"tax":14000.0,"taxableIncome":290000,"netIncome":486000,"effectiveRate":0.028,"marginalRate":0.1,"marginalLevel":"150,001-500,000","taxMethod":"progressive","progressiveTax":14000,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000,"rate":0,"tax":0},{"level":"150,001-500,000","income":140000,"rate":0.1,"tax":14000},{"level":"500,001-1,000,000","income":0,"rate":0.15,"tax":0},{"level":"1,000,001-2,000,000","income":0,"rate":0.2,"tax":0},{"level":"2,000,001 ขึ้นไป","income":0,"rate":0.35,"tax":0}]}`},
+		{`{"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"k-receipt","amount":50000.0},{"allowanceType":"donation","amount":100000.0}]}`, sqlFunc, `{"tax":14000.0,"taxableIncome":290000,"netIncome":486000,"effectiveRate":0.028,"marginalRate":0.1,"marginalLevel":"150,001-500,000","taxMethod":"progressive","progressiveTax":14000,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000,"rate":0,"tax":0},{"level":"150,001-500,000","income":140000,"rate":0.1,"tax":14000},{"level":"500,001-1,000,000","income":0,"rate":0.15,"tax":0},{"level":"1,000,001-2,000,000","income":0,"rate":0.2,"tax":0},{"level":"2,000,001 ขึ้นไป","income":0,"rate":0.35,"tax":0}]}`},
+		{`{"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"k-receipt","amount":49999.0},{"allowanceType":"donation","amount":100000.0}]}`, sqlFunc, `{"tax":14000.0,"taxableIncome":290001,"netIncome":486000,"effectiveRate":0.028,"marginalRate":0.1,"marginalLevel":"150,001-500,000","taxMethod":"progressive","progressiveTax":14000,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000,"rate":0,"tax":0},{"level":"150,001-500,000","income":140001,"rate":0.1,"tax":14000.1},{"level":"500,001-1,000,000","income":0,"rate":0.15,"tax":0},{"level":"1,000,001-2,000,000","income":0,"rate":0.2,"tax":0},{"level":"2,000,001 ขึ้นไป","income":0,"rate":0.35,"tax":0}]}`},
+		{`{"totalIncome":500000.07,"wht":0.05,"allowances":[{"allowanceType":"donation","amount":0.0}]}`, sqlFunc, `{"tax":28999.95,"taxableIncome":440000.07,"netIncome":471000.07,"effectiveRate":0.058,"marginalRate":0.1,"marginalLevel":"150,001-500,000","taxMethod":"progressive","progressiveTax":29000,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000,"rate":0,"tax":0},{"level":"150,001-500,000","income":290000.07,"rate":0.1,"tax":29000.01},{"level":"500,001-1,000,000","income":0,"rate":0.15,"tax":0},{"level":"1,000,001-2,000,000","income":0,"rate":0.2,"tax":0},{"level":"2,000,001 ขึ้นไป","income":0,"rate":0.35,"tax":0}]}`},
 	}
 
 	for _, tc := range testCases {
@@ -240,7 +308,7 @@ func TestTaxCalculateHandler(t *testing.T) {
 		c := e.NewContext(req, rec)
 
 		db, err := tc.sqlFn()
-		h := New(db)
+		h := newTestHandler(db)
 		// Assertions
 		assert.NoError(t, err)
 		if assert.NoError(t, h.TaxCalculateHandler(c)) {
@@ -251,4 +319,155 @@ func TestTaxCalculateHandler(t *testing.T) {
 	}
 }
 
-// TODO : write upload test
+func TestTaxCalculateHandlerWithTaxYear(t *testing.T) {
+	testCases := []struct {
+		reqBody         string
+		bRows           *sqlmock.Rows
+		expectedResBody string
+	}{
+		{`{"taxYear":2566,"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"k-receipt","amount":200000.0}]}`, sqlmock.NewRows([]string{"lower_bound", "upper_bound", "rate"}), `{"tax":25000.0,"taxableIncome":400000.0,"netIncome":475000.0,"effectiveRate":0.05,"marginalRate":0.1,"marginalLevel":"150,001-500,000","taxMethod":"progressive","progressiveTax":25000,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000.0,"rate":0,"tax":0.0},{"level":"150,001-500,000","income":250000.0,"rate":0.1,"tax":25000.0},{"level":"500,001-1,000,000","income":0.0,"rate":0.15,"tax":0.0},{"level":"1,000,001-2,000,000","income":0.0,"rate":0.2,"tax":0.0},{"level":"2,000,001 ขึ้นไป","income":0.0,"rate":0.35,"tax":0.0}]}`},
+		{`{"taxYear":2567,"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"k-receipt","amount":200000.0}]}`, sqlmock.NewRows([]string{"lower_bound", "upper_bound", "rate"}).AddRow(0.0, 100000.0, 0.0).AddRow(100000.0, nil, 0.1), `{"tax":29000.0,"taxableIncome":390000.0,"netIncome":471000.0,"effectiveRate":0.058,"marginalRate":0.1,"marginalLevel":"100,001 ขึ้นไป","taxMethod":"progressive","progressiveTax":29000,"minimumTax":0,"taxLevel":[{"level":"0-100,000","income":100000.0,"rate":0,"tax":0.0},{"level":"100,001 ขึ้นไป","income":290000.0,"rate":0.1,"tax":29000.0}]}`},
+		{`{"taxYear":2567,"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"k-receipt","amount":200000.0}]}`, sqlmock.NewRows([]string{"lower_bound", "upper_bound", "rate"}), `{"tax":24000.0,"taxableIncome":390000.0,"netIncome":476000.0,"effectiveRate":0.048,"marginalRate":0.1,"marginalLevel":"150,001-500,000","taxMethod":"progressive","progressiveTax":24000,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000.0,"rate":0,"tax":0.0},{"level":"150,001-500,000","income":240000.0,"rate":0.1,"tax":24000.0},{"level":"500,001-1,000,000","income":0.0,"rate":0.15,"tax":0.0},{"level":"1,000,001-2,000,000","income":0.0,"rate":0.2,"tax":0.0},{"level":"2,000,001 ขึ้นไป","income":0.0,"rate":0.35,"tax":0.0}]}`},
+	}
+
+	for _, tc := range testCases {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.reqBody))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		mock.ExpectQuery("SELECT lower_bound, upper_bound, rate FROM \"tax_brackets\" WHERE tax_year = $1 ORDER BY lower_bound;").WillReturnRows(tc.bRows)
+		h := newTestHandler(db)
+
+		assert.NoError(t, err)
+		if assert.NoError(t, h.TaxCalculateHandler(c)) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.JSONEq(t, tc.expectedResBody, rec.Body.String())
+		}
+	}
+}
+
+func TestTaxCalculateHandlerAfterLatestTaxYear(t *testing.T) {
+	// Arrange
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"totalIncome":500000.0,"wht":0.0,"allowances":[]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	mock.ExpectQuery("SELECT lower_bound, upper_bound, rate FROM \"tax_brackets\" WHERE tax_year = $1 ORDER BY lower_bound;").WithArgs(2569).WillReturnRows(sqlmock.NewRows([]string{"lower_bound", "upper_bound", "rate"}))
+	mock.ExpectQuery("SELECT maxAmount FROM \"deductions\" WHERE \"name\" = $1;").WithArgs("personal").WillReturnRows(sqlmock.NewRows([]string{"maxAmount"}).AddRow(60000.0))
+	mock.ExpectQuery("SELECT maxAmount FROM \"deductions\" WHERE \"name\" = $1;").WithArgs("k-receipt").WillReturnRows(sqlmock.NewRows([]string{"maxAmount"}).AddRow(50000.0))
+	h := newTestHandler(db)
+	h.now = func() time.Time { return time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.TaxCalculateHandler(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTaxCalculateHandlerMissingDeduction(t *testing.T) {
+	// Arrange
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"k-receipt","amount":100000.0}]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	mock.ExpectQuery("SELECT lower_bound, upper_bound, rate FROM \"tax_brackets\" WHERE tax_year = $1 ORDER BY lower_bound;").WithArgs(2569).WillReturnRows(sqlmock.NewRows([]string{"lower_bound", "upper_bound", "rate"}))
+	mock.ExpectQuery("SELECT maxAmount FROM \"deductions\" WHERE \"name\" = $1;").WithArgs("personal").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT maxAmount FROM \"deductions\" WHERE \"name\" = $1;").WithArgs("k-receipt").WillReturnRows(sqlmock.NewRows([]string{"maxAmount"}).AddRow(70000.0))
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.TaxCalculateHandler(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"taxableIncome":370000`)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestErrorTaxCalculateHandlerUnsupportedTaxYear(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"taxYear":2500,"totalIncome":500000.0,"wht":0.0}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	db, _, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	h := newTestHandler(db)
+
+	assert.NoError(t, err)
+	assert.Equal(t, problem.New(unsupportedTaxYear, "unsupported tax year 2500"), h.TaxCalculateHandler(c))
+}
+
+func TestErrorTaxCalculateHandlerUnknownAllowance(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"taxYear":2567,"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"lottery","amount":1000.0}]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	mock.ExpectQuery("SELECT lower_bound, upper_bound, rate FROM \"tax_brackets\" WHERE tax_year = $1 ORDER BY lower_bound;").WillReturnRows(sqlmock.NewRows([]string{"lower_bound", "upper_bound", "rate"}))
+	h := newTestHandler(db)
+
+	assert.NoError(t, err)
+	assert.Equal(t, validationError([]problem.FieldError{problem.NewFieldError("allowances[0].allowanceType", codeUnknown, "unknown allowanceType 'lottery'")}), h.TaxCalculateHandler(c))
+}
+
+func TestErrorTaxCalculateHandlerUnknownIncomeCategory(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"taxYear":2567,"incomes":[{"category":"40(9)","amount":500000.0}],"wht":0.0}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	mock.ExpectQuery("SELECT lower_bound, upper_bound, rate FROM \"tax_brackets\" WHERE tax_year = $1 ORDER BY lower_bound;").WillReturnRows(sqlmock.NewRows([]string{"lower_bound", "upper_bound", "rate"}))
+	h := newTestHandler(db)
+
+	assert.NoError(t, err)
+	assert.Equal(t, validationError([]problem.FieldError{problem.NewFieldError("incomes[0].category", codeUnknown, "unknown income category '40(9)'")}), h.TaxCalculateHandler(c))
+}
+
+func TestTaxCalculateHandlerExplain(t *testing.T) {
+	// Arrange
+	reqBody := `{"taxYear":2567,"totalIncome":500000.0,"wht":25000.0,"allowances":[{"allowanceType":"k-receipt","amount":200000.0},{"allowanceType":"donation","amount":50000.0}]}`
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/?explain=true", strings.NewReader(reqBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	mock.ExpectQuery("SELECT lower_bound, upper_bound, rate FROM \"tax_brackets\" WHERE tax_year = $1 ORDER BY lower_bound;").WillReturnRows(sqlmock.NewRows([]string{"lower_bound", "upper_bound", "rate"}))
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.TaxCalculateHandler(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"tax":0.0,"taxRefund":6000.0,"taxableIncome":340000.0,"netIncome":481000.0,"effectiveRate":0.038,"marginalRate":0.1,"marginalLevel":"150,001-500,000","taxMethod":"progressive","progressiveTax":19000,"minimumTax":0,"taxLevel":[{"level":"0-150,000","income":150000.0,"rate":0,"tax":0.0},{"level":"150,001-500,000","income":190000.0,"rate":0.1,"tax":19000.0},{"level":"500,001-1,000,000","income":0.0,"rate":0.15,"tax":0.0},{"level":"1,000,001-2,000,000","income":0.0,"rate":0.2,"tax":0.0},{"level":"2,000,001 ขึ้นไป","income":0.0,"rate":0.35,"tax":0.0}],"steps":[
+			{"step":"totalIncome","amount":500000.0},
+			{"step":"personalDeduction","amount":60000.0},
+			{"step":"allowance","allowanceType":"donation","requested":50000.0,"cap":100000.0,"amount":50000.0},
+			{"step":"allowance","allowanceType":"k-receipt","requested":200000.0,"cap":50000.0,"amount":50000.0},
+			{"step":"taxableIncome","amount":340000.0},
+			{"step":"taxLevel","level":"0-150,000","base":150000.0,"rate":0,"amount":0.0},
+			{"step":"taxLevel","level":"150,001-500,000","base":190000.0,"rate":0.1,"amount":19000.0},
+			{"step":"taxLevel","level":"500,001-1,000,000","base":0.0,"rate":0.15,"amount":0.0},
+			{"step":"taxLevel","level":"1,000,001-2,000,000","base":0.0,"rate":0.2,"amount":0.0},
+			{"step":"taxLevel","level":"2,000,001 ขึ้นไป","base":0.0,"rate":0.35,"amount":0.0},
+			{"step":"grossTax","amount":19000.0},
+			{"step":"wht","amount":25000.0},
+			{"step":"taxRefund","amount":6000.0}
+		]}`, rec.Body.String())
+	}
+}
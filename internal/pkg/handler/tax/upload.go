@@ -0,0 +1,376 @@
+package tax
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
+	"github.com/labstack/echo/v4"
+)
+
+// TaxUploadResponseObject is the result of one row of an uploaded file. Line
+// is the line of the file the row starts on. A row with the status "error"
+// carries the errors of its cells in place of the tax.
+type TaxUploadResponseObject struct {
+	Line        int                  `json:"line"`
+	ID          string               `json:"id,omitempty"`
+	Status      string               `json:"status"`
+	TotalIncome *money.Amount        `json:"totalIncome,omitempty"`
+	Tax         *money.Amount        `json:"tax,omitempty"`
+	TaxRefund   money.Amount         `json:"taxRefund,omitempty"`
+	Errors      []problem.FieldError `json:"errors,omitempty"`
+	// record holds the cells of the row and taxLevels the tax of each level,
+	// for the outputs repeating them, and effectiveRate the rate of the tax
+	// before WHT, for the summary
+	record        []string
+	taxLevels     []TaxLevel
+	effectiveRate money.Rate
+}
+
+const (
+	rowStatusOK    = "ok"
+	rowStatusError = "error"
+)
+
+// Upload modes. A strict upload is rejected as a whole when any row fails, a
+// lenient upload returns the rows that passed along with the failed ones.
+const (
+	uploadModeStrict  = "strict"
+	uploadModeLenient = "lenient"
+)
+
+const (
+	columnID          = "id"
+	columnTaxYear     = "taxYear"
+	columnTotalIncome = "totalIncome"
+	columnWht         = "wht"
+)
+
+// uploadColumns maps the columns of an uploaded file by header name. Every
+// other column is an allowance of the type named by its header.
+type uploadColumns struct {
+	header     []string
+	indexes    map[string]int
+	allowances []string
+}
+
+// acceptedColumns lists every header an uploaded file may have.
+func acceptedColumns() []string {
+	return append([]string{columnID, columnTaxYear, columnTotalIncome, columnWht}, allowanceTypes...)
+}
+
+func newUploadColumns(header []string) (uploadColumns, error) {
+	accepted := map[string]bool{}
+	for _, column := range acceptedColumns() {
+		accepted[column] = true
+	}
+	columns := uploadColumns{header: header, indexes: map[string]int{}}
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if !accepted[name] {
+			return uploadColumns{}, fmt.Errorf("unknown column '%s', the accepted columns are %s", name, strings.Join(acceptedColumns(), ", "))
+		}
+		if _, ok := columns.indexes[name]; ok {
+			return uploadColumns{}, fmt.Errorf("duplicate column '%s'", name)
+		}
+		columns.indexes[name] = i
+		if slices.Contains(allowanceTypes, name) {
+			columns.allowances = append(columns.allowances, name)
+		}
+	}
+	if _, ok := columns.indexes[columnTotalIncome]; !ok {
+		return uploadColumns{}, fmt.Errorf("the '%s' column is required", columnTotalIncome)
+	}
+	return columns, nil
+}
+
+// cell returns the trimmed value of the named column, empty when the file
+// doesn't have the column.
+func (columns uploadColumns) cell(record []string, name string) string {
+	i, ok := columns.indexes[name]
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// requestObject reads the request of a row. A row without a taxYear cell is
+// for taxYear. Each cell that can't be read gives an error on its column.
+func (columns uploadColumns) requestObject(record []string, taxYear int) (TaxRequestObject, []problem.FieldError) {
+	errs := []problem.FieldError{}
+	if len(record) != len(columns.header) {
+		message := fmt.Sprintf("the row has %d columns, the header has %d", len(record), len(columns.header))
+		return TaxRequestObject{}, append(errs, problem.NewFieldError("", codeInvalid, message))
+	}
+	requestObject := TaxRequestObject{TaxYear: taxYear}
+	if value := columns.cell(record, columnTaxYear); value != "" {
+		year, err := strconv.Atoi(value)
+		if err != nil {
+			errs = append(errs, problem.NewFieldError(columnTaxYear, codeInvalid, "taxYear must be a number"))
+		} else if _, err := findTaxRule(year); err != nil {
+			errs = append(errs, problem.NewFieldError(columnTaxYear, codeUnsupported, err.Error()))
+		}
+		requestObject.TaxYear = year
+	}
+	amount := func(column string) money.Amount {
+		value, err := parseAmount(columns.cell(record, column))
+		if err != nil {
+			errs = append(errs, problem.NewFieldError(column, codeInvalid, column+" must be a number"))
+		}
+		return value
+	}
+	if columns.cell(record, columnTotalIncome) == "" {
+		errs = append(errs, problem.NewFieldError(columnTotalIncome, codeRequired, "totalIncome is required"))
+	} else {
+		requestObject.TotalIncome = amount(columnTotalIncome)
+	}
+	if columns.cell(record, columnWht) != "" {
+		requestObject.Wht = amount(columnWht)
+	}
+	for _, allowanceType := range columns.allowances {
+		if columns.cell(record, allowanceType) == "" {
+			continue
+		}
+		requestObject.Allowances = append(requestObject.Allowances, Allowance{AllowanceType: allowanceType, Amount: amount(allowanceType)})
+	}
+	return requestObject, errs
+}
+
+// thousandsPattern matches an amount with commas between its thousands.
+var thousandsPattern = regexp.MustCompile(`^[+-]?\d{1,3}(,\d{3})+(\.\d*)?$`)
+
+// parseAmount parses an amount of an uploaded file, which may separate its
+// thousands with commas such as "1,500,000.00".
+func parseAmount(value string) (money.Amount, error) {
+	if thousandsPattern.MatchString(value) {
+		value = strings.ReplaceAll(value, ",", "")
+	}
+	return money.Parse(value)
+}
+
+// rowField names field of the row at index i of an uploaded file, the whole
+// row when field is empty.
+func rowField(i int, field string) string {
+	if field == "" {
+		return fmt.Sprintf("rows[%d]", i)
+	}
+	return fmt.Sprintf("rows[%d].%s", i, field)
+}
+
+// uploadFormOverhead allows for the multipart boundaries and the other form
+// fields on top of the file when limiting the size of an upload.
+const uploadFormOverhead = 1 << 20
+
+func (h handler) TaxUploadCalulateHandler(c echo.Context) error {
+	form, err := h.readUploadForm(c)
+	if err != nil {
+		return err
+	}
+	defer form.file.Close()
+	upload, err := h.newTaxUpload(form.file, form.taxYear, form.fileOptions)
+	if err != nil {
+		return err
+	}
+	defer upload.close()
+	upload.mode = form.mode
+	rules, err := h.takeTaxRuleSnapshot(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	options, err := readUploadOutputOptions(c, rules, upload.columns.header, form.taxYear)
+	if err != nil {
+		return err
+	}
+	return h.calculateUpload(c.Request().Context(), upload, rules, newUploadOutput(c, options))
+}
+
+// uploadForm is the form of an upload with its file open.
+type uploadForm struct {
+	file        multipart.File
+	fileOptions uploadFileOptions
+	taxYear     int
+	mode        string
+}
+
+func (h handler) readUploadForm(c echo.Context) (uploadForm, error) {
+	form := uploadForm{}
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, int64(h.upload.MaxBytes)+uploadFormOverhead)
+	file, err := c.FormFile("taxFile")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) || (err == nil && file.Size > int64(h.upload.MaxBytes)) {
+		return form, problem.Newf(uploadTooLarge, "the file must not be larger than %d bytes", h.upload.MaxBytes)
+	}
+	if errors.Is(err, http.ErrMissingFile) {
+		return form, problem.New(problem.BadRequest, "taxFile is required")
+	}
+	if err != nil {
+		return form, problem.Bind(err)
+	}
+	form.fileOptions, err = newUploadFileOptions(c.FormValue("sheet"), c.FormValue("delimiter"), c.FormValue("encoding"))
+	if err != nil {
+		return form, problem.New(problem.BadRequest, err.Error())
+	}
+	form.mode = c.FormValue("mode")
+	if form.mode == "" {
+		form.mode = uploadModeStrict
+	}
+	if form.mode != uploadModeStrict && form.mode != uploadModeLenient {
+		return form, problem.New(problem.BadRequest, "mode must be 'strict' or 'lenient'")
+	}
+	if value := c.FormValue("taxYear"); value != "" {
+		form.taxYear, err = strconv.Atoi(value)
+		if err != nil {
+			return form, problem.New(problem.BadRequest, "taxYear must be a number")
+		}
+	}
+	if _, err := findTaxRule(h.taxYear(form.taxYear)); err != nil {
+		return form, problem.New(unsupportedTaxYear, err.Error())
+	}
+	form.file, err = file.Open()
+	if err != nil {
+		return form, problem.Wrap(err)
+	}
+	return form, nil
+}
+
+// newTaxUpload reads the header of the file in src, a CSV file or an XLSX
+// workbook.
+func (h handler) newTaxUpload(src io.Reader, taxYear int, options uploadFileOptions) (*taxUpload, error) {
+	reader, err := newRowReader(src, options, h.upload.MaxBytes)
+	if err != nil {
+		return nil, problem.Newf(invalidTaxFile, "Invalid file format. %v", err)
+	}
+	header, _, err := reader.Read()
+	if err != nil {
+		reader.Close()
+		return nil, problem.Newf(invalidTaxFile, "Invalid file format. The file must have a header row: %v", err)
+	}
+	columns, err := newUploadColumns(slices.Clone(header))
+	if err != nil {
+		reader.Close()
+		return nil, problem.New(invalidTaxFile, err.Error())
+	}
+	return &taxUpload{reader: reader, columns: columns, taxYear: taxYear, mode: uploadModeStrict, maxRows: h.upload.MaxRows}, nil
+}
+
+// taxUpload is an uploaded file whose header has been read.
+type taxUpload struct {
+	reader  rowReader
+	columns uploadColumns
+	taxYear int
+	mode    string
+	maxRows int
+	// rows counts the rows read so far
+	rows int
+}
+
+// next reads the next row of upload with its line, io.EOF after the last row.
+func (upload *taxUpload) next() ([]string, int, error) {
+	record, line, err := upload.reader.Read()
+	if err == io.EOF {
+		return nil, 0, err
+	}
+	if err != nil {
+		return nil, 0, problem.Newf(invalidTaxFile, "Invalid file format. %v", err)
+	}
+	if upload.rows == upload.maxRows {
+		return nil, 0, problem.Newf(uploadTooLarge, "the file must not have more than %d rows", upload.maxRows)
+	}
+	upload.rows++
+	return record, line, nil
+}
+
+func (upload *taxUpload) close() error {
+	return upload.reader.Close()
+}
+
+// uploadJob is a row of an upload to calculate, and its result once it is.
+type uploadJob struct {
+	record []string
+	line   int
+	row    TaxUploadResponseObject
+	err    error
+}
+
+// errUploadStopped stops calculating an upload without failing it.
+var errUploadStopped = errors.New("upload stopped")
+
+// calculateUpload calculates the rows of upload with a pool of workers and
+// writes each to output in the order of the file, so only a buffering output
+// holds more than a few rows in memory. Every row is calculated with rules. A
+// streaming output can't take back the rows it wrote, so a strict upload
+// streamed stops at the first failed row. When ctx is done the upload stops
+// with its error.
+func (h handler) calculateUpload(ctx context.Context, upload *taxUpload, rules *taxRuleSnapshot, output uploadOutput) error {
+	stats := newUploadSummary()
+	errs := []problem.FieldError{}
+	next := func() (uploadJob, error) {
+		record, line, err := upload.next()
+		return uploadJob{record: slices.Clone(record), line: line}, err
+	}
+	calculate := func(job uploadJob) uploadJob {
+		job.row, job.err = rules.uploadRow(upload, job.record, job.line)
+		return job
+	}
+	write := func(job uploadJob) error {
+		if job.err != nil {
+			return job.err
+		}
+		if upload.mode == uploadModeStrict {
+			for _, rowErr := range job.row.Errors {
+				errs = append(errs, problem.NewFieldError(rowField(stats.summary.Total, rowErr.Field), rowErr.Code, rowErr.Message))
+			}
+		}
+		stats.addRow(job.row)
+		if err := output.writeRow(job.row); err != nil {
+			return err
+		}
+		if len(errs) > 0 && output.streaming() {
+			return errUploadStopped
+		}
+		return nil
+	}
+	failure := inOrder(ctx, h.upload.Workers, next, calculate, write)
+	if failure == errUploadStopped {
+		failure = nil
+	}
+	if failure == nil && stats.summary.Total == 0 {
+		failure = problem.New(invalidTaxFile, "Invalid file format. The file must have at least one row")
+	}
+	if failure == nil && len(errs) > 0 {
+		failure = validationError(errs)
+	}
+	return output.end(stats.result(), failure)
+}
+
+// uploadRow calculates one row of upload. The error is only for failures other
+// than an invalid row, which are reported on the row itself.
+func (s *taxRuleSnapshot) uploadRow(upload *taxUpload, record []string, line int) (TaxUploadResponseObject, error) {
+	row := TaxUploadResponseObject{Line: line, ID: upload.columns.cell(record, columnID), Status: rowStatusOK, record: record}
+	requestObject, rowErrs := upload.columns.requestObject(record, upload.taxYear)
+	if len(rowErrs) == 0 {
+		rule, err := s.taxRule(requestObject.TaxYear)
+		if err != nil {
+			return row, err
+		}
+		if rowErrs = rule.validateTaxRequest(requestObject); len(rowErrs) == 0 {
+			result := taxCalculate(requestObject, rule)
+			tax, taxRefund := splitTax(result.Tax)
+			row.TotalIncome, row.Tax, row.TaxRefund = &requestObject.TotalIncome, &tax, taxRefund
+			row.taxLevels, row.effectiveRate = result.TaxLevels, money.Ratio(result.GrossTax, result.TotalIncome)
+		}
+	}
+	if len(rowErrs) > 0 {
+		row.Status, row.Errors = rowStatusError, rowErrs
+	}
+	return row, nil
+}
@@ -0,0 +1,378 @@
+package tax
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/kidkrub/assessment-tax/internal/pkg/config"
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/charmap"
+)
+
+func newUploadContext(form map[string]string, content string) (echo.Context, *httptest.ResponseRecorder) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for name, value := range form {
+		writer.WriteField(name, value)
+	}
+	part, _ := writer.CreateFormFile("taxFile", "taxes.csv")
+	part.Write([]byte(content))
+	writer.Close()
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", body)
+	req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+	rec := httptest.NewRecorder()
+	return e.NewContext(req, rec), rec
+}
+
+// expectTaxRuleSnapshot expects the rule sets of every tax year to be read
+// with the default brackets and the deductions of the default tax year.
+func expectTaxRuleSnapshot(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	for _, year := range TaxYears() {
+		mock.ExpectQuery("SELECT lower_bound, upper_bound, rate FROM \"tax_brackets\" WHERE tax_year = $1 ORDER BY lower_bound;").WithArgs(year).WillReturnRows(sqlmock.NewRows([]string{"lower_bound", "upper_bound", "rate"}))
+	}
+	mock.ExpectQuery("SELECT maxAmount FROM \"deductions\" WHERE \"name\" = $1;").WithArgs("personal").WillReturnRows(sqlmock.NewRows([]string{"maxAmount"}).AddRow(60000.0))
+	mock.ExpectQuery("SELECT maxAmount FROM \"deductions\" WHERE \"name\" = $1;").WithArgs("k-receipt").WillReturnRows(sqlmock.NewRows([]string{"maxAmount"}).AddRow(50000.0))
+	mock.ExpectCommit()
+}
+
+func TestNewUploadColumns(t *testing.T) {
+	testCases := []struct {
+		header             []string
+		expectedAllowances []string
+		expectedErr        string
+	}{
+		{[]string{"totalIncome", "wht", "donation"}, []string{"donation"}, ""},
+		{[]string{"id", "k-receipt", " totalIncome ", "taxYear", "donation"}, []string{"k-receipt", "donation"}, ""},
+		{[]string{"wht", "donation"}, nil, "the 'totalIncome' column is required"},
+		{[]string{"totalIncome", "wht", "wht"}, nil, "duplicate column 'wht'"},
+		{[]string{"totalIncome", "bonus"}, nil, "unknown column 'bonus', the accepted columns are id, taxYear, totalIncome, wht, donation, "},
+	}
+
+	for _, tc := range testCases {
+		columns, err := newUploadColumns(tc.header)
+		if tc.expectedErr != "" {
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.expectedErr)
+			}
+			continue
+		}
+		if assert.NoError(t, err) {
+			assert.Equal(t, tc.expectedAllowances, columns.allowances)
+		}
+	}
+}
+
+func TestTaxUploadCalulateHandler(t *testing.T) {
+	// Arrange
+	c, rec := newUploadContext(map[string]string{"taxYear": "2567"}, "id,taxYear,k-receipt,wht,totalIncome\nA,,200000.0,0.0,500000.0\nB,2566,,24000.0,500000.0\n")
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	expectTaxRuleSnapshot(mock)
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.TaxUploadCalulateHandler(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{
+			"summary":{"total":2,"succeeded":2,"failed":0,"totalIncome":1000000,"tax":29000,"taxRefund":0,"averageEffectiveRate":0.053,"levels":[{"level":"0-150,000","rate":0,"taxpayers":0,"income":300000,"tax":0},{"level":"150,001-500,000","rate":0.1,"taxpayers":2,"income":530000,"tax":53000},{"level":"500,001-1,000,000","rate":0.15,"taxpayers":0,"income":0,"tax":0},{"level":"1,000,001-2,000,000","rate":0.2,"taxpayers":0,"income":0,"tax":0},{"level":"2,000,001 ขึ้นไป","rate":0.35,"taxpayers":0,"income":0,"tax":0}],"taxStatistics":{"min":5000,"p25":5000,"median":5000,"p75":24000,"p90":24000,"p99":24000,"max":24000}},
+			"taxes":[
+				{"line":2,"id":"A","status":"ok","totalIncome":500000.0,"tax":24000.0},
+				{"line":3,"id":"B","status":"ok","totalIncome":500000.0,"tax":5000.0}
+			]}`, rec.Body.String())
+	}
+}
+
+func TestTaxUploadCalulateHandlerXLSX(t *testing.T) {
+	// Arrange
+	content := newWorkbook(t, []string{"Notes", "Taxes"}, map[string][][]interface{}{
+		"Taxes": {{"Taxes of 2567"}, {"id", "totalIncome", "wht"}, {"A", 500000, 0}, {"B", 500000}},
+	})
+	c, rec := newUploadContext(map[string]string{"taxYear": "2567", "sheet": "Taxes"}, content)
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	expectTaxRuleSnapshot(mock)
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.TaxUploadCalulateHandler(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{
+			"summary":{"total":2,"succeeded":2,"failed":0,"totalIncome":1000000,"tax":58000,"taxRefund":0,"averageEffectiveRate":0.058,"levels":[{"level":"0-150,000","rate":0,"taxpayers":0,"income":300000,"tax":0},{"level":"150,001-500,000","rate":0.1,"taxpayers":2,"income":580000,"tax":58000},{"level":"500,001-1,000,000","rate":0.15,"taxpayers":0,"income":0,"tax":0},{"level":"1,000,001-2,000,000","rate":0.2,"taxpayers":0,"income":0,"tax":0},{"level":"2,000,001 ขึ้นไป","rate":0.35,"taxpayers":0,"income":0,"tax":0}],"taxStatistics":{"min":29000,"p25":29000,"median":29000,"p75":29000,"p90":29000,"p99":29000,"max":29000}},
+			"taxes":[
+				{"line":3,"id":"A","status":"ok","totalIncome":500000.0,"tax":29000.0},
+				{"line":4,"id":"B","status":"ok","totalIncome":500000.0,"tax":29000.0}
+			]}`, rec.Body.String())
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected money.Amount
+		hasError bool
+	}{
+		{"1500000.00", money.New(1500000), false},
+		{"1,500,000.00", money.New(1500000), false},
+		{"-1,000", money.New(-1000), false},
+		{"150,000.5", money.FromFloat(150000.5), false},
+		{"1,50,000", 0, true},
+		{"1,5", 0, true},
+		{",150", 0, true},
+	}
+
+	for _, tc := range testCases {
+		amount, err := parseAmount(tc.value)
+
+		assert.Equal(t, tc.hasError, err != nil, tc.value)
+		if !tc.hasError {
+			assert.Equal(t, tc.expected, amount, tc.value)
+		}
+	}
+}
+
+func TestTaxUploadCalulateHandlerDialect(t *testing.T) {
+	// Arrange
+	content, _ := charmap.Windows874.NewEncoder().String("id;totalIncome;wht\nสมชาย;\"500,000.00\";0\n")
+	c, rec := newUploadContext(map[string]string{"taxYear": "2567"}, content)
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	expectTaxRuleSnapshot(mock)
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.TaxUploadCalulateHandler(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{
+			"summary":{"total":1,"succeeded":1,"failed":0,"totalIncome":500000,"tax":29000,"taxRefund":0,"averageEffectiveRate":0.058,"levels":[{"level":"0-150,000","rate":0,"taxpayers":0,"income":150000,"tax":0},{"level":"150,001-500,000","rate":0.1,"taxpayers":1,"income":290000,"tax":29000},{"level":"500,001-1,000,000","rate":0.15,"taxpayers":0,"income":0,"tax":0},{"level":"1,000,001-2,000,000","rate":0.2,"taxpayers":0,"income":0,"tax":0},{"level":"2,000,001 ขึ้นไป","rate":0.35,"taxpayers":0,"income":0,"tax":0}],"taxStatistics":{"min":29000,"p25":29000,"median":29000,"p75":29000,"p90":29000,"p99":29000,"max":29000}},
+			"taxes":[{"line":2,"id":"สมชาย","status":"ok","totalIncome":500000.0,"tax":29000.0}]
+			}`, rec.Body.String())
+	}
+}
+
+func TestTaxUploadCalulateHandlerRuleSnapshot(t *testing.T) {
+	// Arrange
+	content := "id,totalIncome,k-receipt\nA,500000.0,100000.0\nB,500000.0,\nC,500000.0,10000.0\nD,500000.0,\nE,500000.0,\n"
+	c, rec := newUploadContext(map[string]string{}, content)
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	expectTaxRuleSnapshot(mock)
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.TaxUploadCalulateHandler(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{
+			"summary":{"total":5,"succeeded":5,"failed":0,"totalIncome":2500000,"tax":139000,"taxRefund":0,"averageEffectiveRate":0.0556,"levels":[{"level":"0-150,000","rate":0,"taxpayers":0,"income":750000,"tax":0},{"level":"150,001-500,000","rate":0.1,"taxpayers":5,"income":1390000,"tax":139000},{"level":"500,001-1,000,000","rate":0.15,"taxpayers":0,"income":0,"tax":0},{"level":"1,000,001-2,000,000","rate":0.2,"taxpayers":0,"income":0,"tax":0},{"level":"2,000,001 ขึ้นไป","rate":0.35,"taxpayers":0,"income":0,"tax":0}],"taxStatistics":{"min":24000,"p25":28000,"median":29000,"p75":29000,"p90":29000,"p99":29000,"max":29000}},
+			"taxes":[
+				{"line":2,"id":"A","status":"ok","totalIncome":500000.0,"tax":24000.0},
+				{"line":3,"id":"B","status":"ok","totalIncome":500000.0,"tax":29000.0},
+				{"line":4,"id":"C","status":"ok","totalIncome":500000.0,"tax":28000.0},
+				{"line":5,"id":"D","status":"ok","totalIncome":500000.0,"tax":29000.0},
+				{"line":6,"id":"E","status":"ok","totalIncome":500000.0,"tax":29000.0}
+			]}`, rec.Body.String())
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestErrorTaxUploadCalulateHandlerUnknownColumn(t *testing.T) {
+	// Arrange
+	c, _ := newUploadContext(map[string]string{"taxYear": "2567"}, "totalIncome,bonus\n500000.0,0.0\n")
+	db, _, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if err, ok := h.TaxUploadCalulateHandler(c).(*problem.Error); assert.True(t, ok) {
+		assert.Equal(t, invalidTaxFile, err.Type)
+	}
+}
+
+func TestErrorTaxUploadCalulateHandlerInvalidRows(t *testing.T) {
+	// Arrange
+	c, _ := newUploadContext(map[string]string{"taxYear": "2567"}, "totalIncome,wht,donation\n500000.0,0.0,0.0\n-1.0,0.0,0.0\n100000.0,200000.0,-5.0\n")
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	expectTaxRuleSnapshot(mock)
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, validationError([]problem.FieldError{
+		problem.NewFieldError("rows[1].totalIncome", codeNegative, "totalIncome must not be negative"),
+		problem.NewFieldError("rows[2].wht", codeExceedsIncome, "wht must not exceed the total income"),
+		problem.NewFieldError("rows[2].allowances[0].amount", codeNegative, "allowances[0].amount must not be negative"),
+	}), h.TaxUploadCalulateHandler(c))
+}
+
+func TestErrorTaxUploadCalulateHandlerRowTaxYear(t *testing.T) {
+	// Arrange
+	c, _ := newUploadContext(map[string]string{"taxYear": "2567"}, "taxYear,totalIncome\n2500,500000.0\nabc,500000.0\n")
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	expectTaxRuleSnapshot(mock)
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, validationError([]problem.FieldError{
+		problem.NewFieldError("rows[0].taxYear", codeUnsupported, "unsupported tax year 2500"),
+		problem.NewFieldError("rows[1].taxYear", codeInvalid, "taxYear must be a number"),
+	}), h.TaxUploadCalulateHandler(c))
+}
+
+func TestTaxUploadCalulateHandlerLenient(t *testing.T) {
+	// Arrange
+	c, rec := newUploadContext(map[string]string{"taxYear": "2567", "mode": "lenient"}, "id,totalIncome,wht,donation\n\"A\nB\",500000.0,0.0,0.0\nC,abc,0.0,xyz\nD,,0.0\nE,100000.0,200000.0,\n")
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	expectTaxRuleSnapshot(mock)
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	if assert.NoError(t, h.TaxUploadCalulateHandler(c)) {
+		assert.Equal(t, http.StatusMultiStatus, rec.Code)
+		assert.JSONEq(t, `{
+			"summary":{"total":4,"succeeded":1,"failed":3,"totalIncome":500000,"tax":29000,"taxRefund":0,"averageEffectiveRate":0.058,"levels":[{"level":"0-150,000","rate":0,"taxpayers":0,"income":150000,"tax":0},{"level":"150,001-500,000","rate":0.1,"taxpayers":1,"income":290000,"tax":29000},{"level":"500,001-1,000,000","rate":0.15,"taxpayers":0,"income":0,"tax":0},{"level":"1,000,001-2,000,000","rate":0.2,"taxpayers":0,"income":0,"tax":0},{"level":"2,000,001 ขึ้นไป","rate":0.35,"taxpayers":0,"income":0,"tax":0}],"taxStatistics":{"min":29000,"p25":29000,"median":29000,"p75":29000,"p90":29000,"p99":29000,"max":29000}},
+			"taxes":[
+				{"line":2,"id":"A\nB","status":"ok","totalIncome":500000.0,"tax":29000.0},
+				{"line":4,"id":"C","status":"error","errors":[
+					{"field":"totalIncome","code":"invalid","message":"totalIncome must be a number"},
+					{"field":"donation","code":"invalid","message":"donation must be a number"}
+				]},
+				{"line":5,"id":"D","status":"error","errors":[
+					{"field":"","code":"invalid","message":"the row has 3 columns, the header has 4"}
+				]},
+				{"line":6,"id":"E","status":"error","errors":[
+					{"field":"wht","code":"exceedsIncome","message":"wht must not exceed the total income"}
+				]}
+			]}`, rec.Body.String())
+	}
+}
+
+func TestErrorTaxUploadCalulateHandlerInvalidCells(t *testing.T) {
+	// Arrange
+	c, _ := newUploadContext(map[string]string{"taxYear": "2567"}, "totalIncome,wht,donation\nabc,0.0,0.0\n,0.0,0.0\n500000.0\n")
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	expectTaxRuleSnapshot(mock)
+	h := newTestHandler(db)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, validationError([]problem.FieldError{
+		problem.NewFieldError("rows[0].totalIncome", codeInvalid, "totalIncome must be a number"),
+		problem.NewFieldError("rows[1].totalIncome", codeRequired, "totalIncome is required"),
+		problem.NewFieldError("rows[2]", codeInvalid, "the row has 1 columns, the header has 3"),
+	}), h.TaxUploadCalulateHandler(c))
+}
+
+func TestErrorTaxUploadCalulateHandlerMalformedFile(t *testing.T) {
+	testCases := []struct {
+		form    map[string]string
+		content string
+	}{
+		{map[string]string{}, "totalIncome,wht\n\"500000.0,0.0\n"},
+		{map[string]string{}, "totalIncome,wht\n"},
+		{map[string]string{}, ""},
+		{map[string]string{}, "\x00\x01\x02\x03"},
+		{map[string]string{"mode": "all"}, "totalIncome,wht\n500000.0,0.0\n"},
+		{map[string]string{"delimiter": "::"}, "totalIncome,wht\n500000.0,0.0\n"},
+		{map[string]string{"encoding": "latin1"}, "totalIncome,wht\n500000.0,0.0\n"},
+	}
+
+	for _, tc := range testCases {
+		tc.form["taxYear"] = "2567"
+		c, _ := newUploadContext(tc.form, tc.content)
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		expectTaxRuleSnapshot(mock)
+		h := newTestHandler(db)
+
+		err := h.TaxUploadCalulateHandler(c)
+
+		if assert.Error(t, err) {
+			assert.Equal(t, http.StatusBadRequest, err.(*problem.Error).Type.Status)
+		}
+	}
+}
+
+func TestErrorTaxUploadCalulateHandlerForm(t *testing.T) {
+	testCases := []struct {
+		contentType string
+		body        string
+		expected    string
+	}{
+		{"multipart/form-data; boundary=x", "--x\r\nContent-Disposition: form-data; name=\"taxYear\"\r\n\r\n2567\r\n--x--\r\n", "taxFile is required"},
+		{echo.MIMEApplicationJSON, `{"taxYear":2567}`, "bad request body"},
+	}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body))
+		req.Header.Set(echo.HeaderContentType, tc.contentType)
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+		db, _, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		h := newTestHandler(db)
+
+		err := h.TaxUploadCalulateHandler(c)
+
+		if assert.Error(t, err) {
+			assert.Equal(t, problem.BadRequest, err.(*problem.Error).Type)
+			assert.Equal(t, tc.expected, err.(*problem.Error).Detail)
+		}
+	}
+}
+
+func TestErrorTaxUploadCalulateHandlerLimits(t *testing.T) {
+	testCases := []struct {
+		limits  config.Upload
+		content string
+	}{
+		{config.Upload{MaxRows: 2, MaxBytes: testUpload.MaxBytes, Workers: testUpload.Workers}, "totalIncome\n1.0\n2.0\n3.0\n"},
+		{config.Upload{MaxRows: testUpload.MaxRows, MaxBytes: 16, Workers: testUpload.Workers}, "totalIncome\n500000.0\n"},
+		{config.Upload{MaxRows: testUpload.MaxRows, MaxBytes: 16, Workers: testUpload.Workers}, strings.Repeat("totalIncome\n", 1<<17)},
+	}
+
+	for _, tc := range testCases {
+		c, _ := newUploadContext(map[string]string{"taxYear": "2567"}, tc.content)
+		db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		expectTaxRuleSnapshot(mock)
+		h := newTestHandler(db)
+		h.upload = tc.limits
+
+		err := h.TaxUploadCalulateHandler(c)
+
+		if err, ok := err.(*problem.Error); assert.True(t, ok) {
+			assert.Equal(t, uploadTooLarge, err.Type)
+		}
+	}
+}
+
+func TestErrorTaxUploadCalulateHandlerWorkbookBomb(t *testing.T) {
+	// Arrange
+	// the workbook is under 64 KiB zipped but unzips to over 3 MB
+	rows := [][]interface{}{{"totalIncome"}}
+	for i := 0; i < 100; i++ {
+		rows = append(rows, []interface{}{fmt.Sprint(i) + strings.Repeat("0", 32000)})
+	}
+	c, _ := newUploadContext(map[string]string{"taxYear": "2567"}, newWorkbook(t, []string{"Sheet1"}, map[string][][]interface{}{"Sheet1": rows}))
+	db, _, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	h := newTestHandler(db)
+	h.upload.MaxBytes = 64 << 10
+
+	// Act
+	actual := h.TaxUploadCalulateHandler(c)
+
+	// Assertions
+	assert.NoError(t, err)
+	if actual, ok := actual.(*problem.Error); assert.True(t, ok) {
+		assert.Equal(t, invalidTaxFile, actual.Type)
+		assert.Contains(t, actual.Detail, "unzip size exceeds")
+	}
+}
@@ -0,0 +1,98 @@
+package tax
+
+import (
+	"fmt"
+
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
+)
+
+const (
+	codeNegative      = "negative"
+	codeExceedsIncome = "exceedsIncome"
+	codeUnknown       = "unknown"
+	codeNotAllowed    = "notAllowed"
+	codeInconsistent  = "inconsistent"
+	codeInvalid       = "invalid"
+	codeUnsupported   = "unsupported"
+	codeRequired      = "required"
+)
+
+func validationError(errs []problem.FieldError) error {
+	return problem.New(problem.ValidationFailed, "the request has invalid fields").WithErrors(errs)
+}
+
+// validateTaxRequest checks every field of the request against the rule set
+// and reports all the violations found.
+func (rule TaxRule) validateTaxRequest(inputData TaxRequestObject) []problem.FieldError {
+	errs := []problem.FieldError{}
+	if inputData.TotalIncome < 0 {
+		errs = append(errs, negativeError("totalIncome"))
+	}
+	errs = append(errs, validateIncomes(inputData.Incomes)...)
+	income := inputData.TotalIncome
+	if len(inputData.Incomes) > 0 {
+		income = 0
+		for _, declared := range inputData.Incomes {
+			income += declared.Amount
+		}
+	}
+	if inputData.Wht < 0 {
+		errs = append(errs, negativeError("wht"))
+	} else if income >= 0 && inputData.Wht > income {
+		errs = append(errs, problem.NewFieldError("wht", codeExceedsIncome, "wht must not exceed the total income"))
+	}
+	return append(errs, rule.validateAllowances(inputData.Allowances)...)
+}
+
+func (rule TaxRule) validateAllowances(allowances []Allowance) []problem.FieldError {
+	errs := []problem.FieldError{}
+	for i, allowance := range allowances {
+		field := fmt.Sprintf("allowances[%d].", i)
+		if _, ok := rule.Allowances[allowance.AllowanceType]; !ok {
+			errs = append(errs, problem.NewFieldError(field+"allowanceType", codeUnknown, fmt.Sprintf("unknown allowanceType '%s'", allowance.AllowanceType)))
+		}
+		if allowance.Amount < 0 {
+			errs = append(errs, negativeError(field+"amount"))
+		}
+		if allowance.Count < 0 {
+			errs = append(errs, negativeError(field+"count"))
+		}
+	}
+	return errs
+}
+
+func validateIncomes(incomes []Income) []problem.FieldError {
+	errs := []problem.FieldError{}
+	for i, income := range incomes {
+		field := fmt.Sprintf("incomes[%d].", i)
+		expense, ok := expenseRules[income.Category]
+		if !ok {
+			errs = append(errs, problem.NewFieldError(field+"category", codeUnknown, fmt.Sprintf("unknown income category '%s'", income.Category)))
+		}
+		if income.Amount < 0 {
+			errs = append(errs, negativeError(field+"amount"))
+		}
+		if income.Expense == nil {
+			continue
+		}
+		if ok && !expense.actual {
+			errs = append(errs, problem.NewFieldError(field+"expense", codeNotAllowed, fmt.Sprintf("income category '%s' does not allow actual expenses", income.Category)))
+		} else if *income.Expense < 0 {
+			errs = append(errs, negativeError(field+"expense"))
+		}
+	}
+	return errs
+}
+
+func negativeError(field string) problem.FieldError {
+	return problem.NewFieldError(field, codeNegative, field+" must not be negative")
+}
+
+// prefixFields nests the fields of errs under prefix, e.g. a part of a larger
+// request.
+func prefixFields(prefix string, errs []problem.FieldError) []problem.FieldError {
+	for i := range errs {
+		errs[i].Field = prefix + errs[i].Field
+	}
+	return errs
+}
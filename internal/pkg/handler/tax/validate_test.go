@@ -0,0 +1,49 @@
+package tax
+
+import (
+	"testing"
+
+	"github.com/kidkrub/assessment-tax/internal/pkg/money"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTaxRequest(t *testing.T) {
+	// Arrange
+	rule := taxRules[2567]
+	negative := money.New(-1)
+	expense := money.New(1000)
+	testCases := []struct {
+		inputData TaxRequestObject
+		expected  []problem.FieldError
+	}{
+		{TaxRequestObject{TotalIncome: money.New(500000), Wht: money.New(25000), Allowances: []Allowance{{AllowanceType: "donation", Amount: money.New(200000)}, {AllowanceType: "child", Amount: money.New(30000), Count: 1}}}, []problem.FieldError{}},
+		{TaxRequestObject{Incomes: []Income{{Category: "40(1)", Amount: money.New(300000)}, {Category: "40(6)", Amount: money.New(100000), Expense: &expense}}, Wht: money.New(400000)}, []problem.FieldError{}},
+		{TaxRequestObject{TotalIncome: money.New(-1), Wht: money.New(-1)}, []problem.FieldError{
+			problem.NewFieldError("totalIncome", codeNegative, "totalIncome must not be negative"),
+			problem.NewFieldError("wht", codeNegative, "wht must not be negative"),
+		}},
+		{TaxRequestObject{TotalIncome: money.New(100000), Wht: money.New(100001)}, []problem.FieldError{
+			problem.NewFieldError("wht", codeExceedsIncome, "wht must not exceed the total income"),
+		}},
+		{TaxRequestObject{TotalIncome: money.New(500000), Allowances: []Allowance{{AllowanceType: "lottery", Amount: money.New(-5)}, {AllowanceType: "child", Count: -1}}}, []problem.FieldError{
+			problem.NewFieldError("allowances[0].allowanceType", codeUnknown, "unknown allowanceType 'lottery'"),
+			problem.NewFieldError("allowances[0].amount", codeNegative, "allowances[0].amount must not be negative"),
+			problem.NewFieldError("allowances[1].count", codeNegative, "allowances[1].count must not be negative"),
+		}},
+		{TaxRequestObject{Incomes: []Income{{Category: "40(9)", Amount: money.New(-1)}, {Category: "40(1)", Amount: money.New(100), Expense: &expense}, {Category: "40(8)", Amount: money.New(100), Expense: &negative}}}, []problem.FieldError{
+			problem.NewFieldError("incomes[0].category", codeUnknown, "unknown income category '40(9)'"),
+			problem.NewFieldError("incomes[0].amount", codeNegative, "incomes[0].amount must not be negative"),
+			problem.NewFieldError("incomes[1].expense", codeNotAllowed, "income category '40(1)' does not allow actual expenses"),
+			problem.NewFieldError("incomes[2].expense", codeNegative, "incomes[2].expense must not be negative"),
+		}},
+	}
+
+	for _, tc := range testCases {
+		// Act
+		actual := rule.validateTaxRequest(tc.inputData)
+
+		// Assertions
+		assert.Equal(t, tc.expected, actual)
+	}
+}
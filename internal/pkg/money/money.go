@@ -0,0 +1,276 @@
+// Package money provides exact fixed-point amounts and rates for tax
+// calculation, so baht values never pass through float64 arithmetic.
+//
+// Rounding follows Revenue Department practice:
+//   - inputs and intermediate results are rounded half away from zero to the satang
+//   - the final tax drops its satang, see Amount.Truncate
+package money
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// Amount is a baht value stored as a whole number of satang.
+type Amount int64
+
+// Rate is a ratio such as a tax rate stored in millionths, so 0.35 is 350000.
+type Rate int64
+
+const (
+	Satang Amount = 1
+	Baht   Amount = 100
+
+	rateScale = 1000000
+	OneRate   = Rate(rateScale)
+)
+
+// New returns the amount of whole baht.
+func New(baht int64) Amount {
+	return Amount(baht) * Baht
+}
+
+// FromFloat converts a float to the nearest satang.
+func FromFloat(value float64) Amount {
+	return Amount(math.Round(value * float64(Baht)))
+}
+
+// Parse reads a decimal string such as "1500000.00" or "-12.5". Digits past
+// the satang are rounded half away from zero.
+func Parse(value string) (Amount, error) {
+	units, err := parseDecimal(value, 2)
+	return Amount(units), err
+}
+
+func (a Amount) Float64() float64 {
+	return float64(a) / float64(Baht)
+}
+
+// Truncate drops the satang, rounding toward zero.
+func (a Amount) Truncate() Amount {
+	return a / Baht * Baht
+}
+
+// Mul multiplies the amount by the rate, rounded to the nearest satang and
+// saturated to the range of Amount.
+func (a Amount) Mul(r Rate) Amount {
+	return Amount(mulDiv(int64(a), int64(r), rateScale))
+}
+
+// Div divides the amount by the divisor, rounded to the nearest satang.
+func (a Amount) Div(divisor int64) Amount {
+	return Amount(mulDiv(int64(a), 1, divisor))
+}
+
+func (a Amount) String() string {
+	return formatDecimal(int64(a), 2, 2)
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(formatDecimal(int64(a), 2, 0)), nil
+}
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	value, err := Parse(strings.Trim(string(data), `"`))
+	if err != nil {
+		return err
+	}
+	*a = value
+	return nil
+}
+
+func (a *Amount) Scan(src interface{}) error {
+	units, err := scanDecimal(src, 2)
+	*a = Amount(units)
+	return err
+}
+
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+// FormatBaht prints a whole number with thousands separators, e.g. "2,000,001".
+func FormatBaht(a Amount) string {
+	digits := formatDecimal(int64(a), 2, 0)
+	whole, fraction, hasFraction := strings.Cut(strings.TrimPrefix(digits, "-"), ".")
+	var sb strings.Builder
+	if a < 0 {
+		sb.WriteByte('-')
+	}
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteRune(digit)
+	}
+	if hasFraction {
+		sb.WriteString("." + fraction)
+	}
+	return sb.String()
+}
+
+func Min(a, b Amount) Amount {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func Max(a, b Amount) Amount {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// RateFromFloat converts a float to the nearest millionth.
+func RateFromFloat(value float64) Rate {
+	return Rate(math.Round(value * rateScale))
+}
+
+// ParseRate reads a decimal string such as "0.35".
+func ParseRate(value string) (Rate, error) {
+	units, err := parseDecimal(value, 6)
+	return Rate(units), err
+}
+
+// Ratio returns a divided by b, or zero when b is zero. It saturates to the
+// range of Rate.
+func Ratio(a, b Amount) Rate {
+	if b == 0 {
+		return 0
+	}
+	return Rate(mulDiv(int64(a), rateScale, int64(b)))
+}
+
+// Div divides the rate by the divisor, rounded to the nearest millionth.
+func (r Rate) Div(divisor int64) Rate {
+	return Rate(mulDiv(int64(r), 1, divisor))
+}
+
+func (r Rate) Float64() float64 {
+	return float64(r) / rateScale
+}
+
+func (r Rate) String() string {
+	return formatDecimal(int64(r), 6, 0)
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	value, err := ParseRate(strings.Trim(string(data), `"`))
+	if err != nil {
+		return err
+	}
+	*r = value
+	return nil
+}
+
+func (r *Rate) Scan(src interface{}) error {
+	units, err := scanDecimal(src, 6)
+	*r = Rate(units)
+	return err
+}
+
+func (r Rate) Value() (driver.Value, error) {
+	return r.String(), nil
+}
+
+var scales = []int64{1, 10, 100, 1000, 10000, 100000, 1000000}
+
+// decimalPattern is a plain decimal with an optional exponent. The exponent is
+// bounded, since any larger one is out of range or rounds to zero anyway.
+var decimalPattern = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)([eE][+-]?\d{1,3})?$`)
+
+func parseDecimal(value string, places int) (int64, error) {
+	value = strings.TrimSpace(value)
+	if !decimalPattern.MatchString(value) {
+		return 0, fmt.Errorf("invalid decimal %q", value)
+	}
+	rat, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return 0, fmt.Errorf("invalid decimal %q", value)
+	}
+	rat.Mul(rat, new(big.Rat).SetInt64(scales[places]))
+	units := roundHalfAway(rat.Num(), rat.Denom())
+	if !units.IsInt64() {
+		return 0, fmt.Errorf("decimal %q is out of range", value)
+	}
+	return units.Int64(), nil
+}
+
+func scanDecimal(src interface{}, places int) (int64, error) {
+	switch value := src.(type) {
+	case nil:
+		return 0, nil
+	case int64:
+		return value * scales[places], nil
+	case float64:
+		return int64(math.Round(value * float64(scales[places]))), nil
+	case []byte:
+		return parseDecimal(string(value), places)
+	case string:
+		return parseDecimal(value, places)
+	}
+	return 0, fmt.Errorf("cannot scan %T into a decimal", src)
+}
+
+// formatDecimal prints units with at least minPlaces and at most places
+// decimal digits, trimming trailing zeros in between.
+func formatDecimal(units int64, places, minPlaces int) string {
+	sign := ""
+	if units < 0 {
+		sign = "-"
+	}
+	abs := new(big.Int).Abs(big.NewInt(units)).String()
+	if len(abs) <= places {
+		abs = strings.Repeat("0", places-len(abs)+1) + abs
+	}
+	whole, fraction := abs[:len(abs)-places], abs[len(abs)-places:]
+	for len(fraction) > minPlaces && strings.HasSuffix(fraction, "0") {
+		fraction = fraction[:len(fraction)-1]
+	}
+	if fraction == "" {
+		return sign + whole
+	}
+	return sign + whole + "." + fraction
+}
+
+// mulDiv returns a*b/divisor rounded half away from zero, saturated to the
+// range of int64.
+func mulDiv(a, b, divisor int64) int64 {
+	product := new(big.Int).Mul(big.NewInt(a), big.NewInt(b))
+	quo := roundHalfAway(product, big.NewInt(divisor))
+	if !quo.IsInt64() {
+		if quo.Sign() < 0 {
+			return math.MinInt64
+		}
+		return math.MaxInt64
+	}
+	return quo.Int64()
+}
+
+func roundHalfAway(num, denom *big.Int) *big.Int {
+	quo, rem := new(big.Int).QuoRem(num, denom, new(big.Int))
+	if new(big.Int).Abs(new(big.Int).Mul(rem, big.NewInt(2))).Cmp(new(big.Int).Abs(denom)) >= 0 {
+		if (num.Sign() < 0) != (denom.Sign() < 0) {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	return quo
+}
@@ -0,0 +1,130 @@
+package money
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected Amount
+	}{
+		{"0", 0},
+		{"29000", 2900000},
+		{"14000.1", 1400010},
+		{"310000.35", 31000035},
+		{"-12.5", -1250},
+		{"0.005", 1},
+		{"-0.005", -1},
+		{"0.004", 0},
+		{"1e3", 100000},
+		{"+.5", 50},
+		{"2.5E-1", 25},
+	}
+
+	for _, tc := range testCases {
+		actual, err := Parse(tc.value)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, actual, "parse is incorrect for %q", tc.value)
+	}
+
+	for _, value := range []string{"", "abc", "NaN", "1/3", "1,000", "0x10", "1_000", "0b1", "1e", "1e99999", "99999999999999999999"} {
+		_, err := Parse(value)
+		assert.Error(t, err, "parse should fail for %q", value)
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	testCases := []struct {
+		amount   Amount
+		expected string
+	}{
+		{0, "0"},
+		{New(29000), "29000"},
+		{1400010, "14000.1"},
+		{31000035, "310000.35"},
+		{-5, "-0.05"},
+	}
+
+	for _, tc := range testCases {
+		actual, err := json.Marshal(tc.amount)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, string(actual))
+
+		var amount Amount
+		assert.NoError(t, json.Unmarshal(actual, &amount))
+		assert.Equal(t, tc.amount, amount)
+	}
+}
+
+func TestAmountMul(t *testing.T) {
+	testCases := []struct {
+		amount   Amount
+		rate     Rate
+		expected Amount
+	}{
+		{New(350000), RateFromFloat(0.1), New(35000)},
+		{New(1), RateFromFloat(0.35), 35},
+		{101, RateFromFloat(0.35), 35},
+		{3, RateFromFloat(0.5), 2},
+		{-3, RateFromFloat(0.5), -2},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, tc.amount.Mul(tc.rate))
+	}
+}
+
+func TestAmountTruncate(t *testing.T) {
+	assert.Equal(t, New(310000), Amount(31000035).Truncate())
+	assert.Equal(t, New(-1000), Amount(-100099).Truncate())
+}
+
+func TestScan(t *testing.T) {
+	testCases := []struct {
+		src      interface{}
+		expected Amount
+	}{
+		{[]byte("60000.00"), New(60000)},
+		{"50000.5", 5000050},
+		{60000.0, New(60000)},
+		{int64(100), New(100)},
+		{nil, 0},
+	}
+
+	for _, tc := range testCases {
+		var amount Amount
+		assert.NoError(t, amount.Scan(tc.src))
+		assert.Equal(t, tc.expected, amount)
+	}
+}
+
+func TestRatio(t *testing.T) {
+	assert.Equal(t, RateFromFloat(0.058), Ratio(New(29000), New(500000)))
+	assert.Equal(t, Rate(0), Ratio(New(29000), 0))
+	assert.Equal(t, "0.058", Ratio(New(29000), New(500000)).String())
+	assert.Equal(t, Rate(math.MaxInt64), Ratio(math.MaxInt64, 1))
+	assert.Equal(t, Rate(math.MinInt64), Ratio(math.MinInt64, 1))
+}
+
+func TestAmountMulSaturates(t *testing.T) {
+	assert.Equal(t, Amount(math.MaxInt64), Amount(math.MaxInt64).Mul(2*OneRate))
+	assert.Equal(t, Amount(math.MinInt64), Amount(math.MinInt64).Mul(2*OneRate))
+}
+
+func TestRateDiv(t *testing.T) {
+	assert.Equal(t, RateFromFloat(0.029), RateFromFloat(0.058).Div(2))
+	assert.Equal(t, Rate(333333), OneRate.Div(3))
+	assert.Equal(t, Rate(666667), Rate(2*rateScale).Div(3))
+}
+
+func TestFormatBaht(t *testing.T) {
+	assert.Equal(t, "0", FormatBaht(0))
+	assert.Equal(t, "150,001", FormatBaht(New(150001)))
+	assert.Equal(t, "2,000,001", FormatBaht(New(2000001)))
+	assert.Equal(t, "1,500,000.5", FormatBaht(150000050))
+}
@@ -0,0 +1,165 @@
+// Package problem renders errors as RFC 7807 problem details.
+package problem
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// Type identifies a kind of problem. URI is stable so clients can tell
+// problems apart without parsing the detail.
+type Type struct {
+	URI    string
+	Title  string
+	Status int
+}
+
+// typeBase is relative, so the type URIs resolve against the API itself.
+const typeBase = "/problems/"
+
+var (
+	BadRequest       = Type{typeBase + "bad-request", "Bad request", http.StatusBadRequest}
+	ValidationFailed = Type{typeBase + "validation-failed", "Validation failed", http.StatusUnprocessableEntity}
+	NotFound         = Type{typeBase + "not-found", "Not found", http.StatusNotFound}
+	Internal         = Type{typeBase + "internal", "Internal server error", http.StatusInternalServerError}
+)
+
+// NewType declares a problem type of a domain, e.g. NewType("unsupported-tax-year", ...).
+func NewType(name, title string, status int) Type {
+	return Type{typeBase + name, title, status}
+}
+
+// FieldError describes one invalid field of a request. Field is the JSON path
+// of the field, such as "allowances[1].amount".
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func NewFieldError(field, code, message string) FieldError {
+	return FieldError{Field: field, Code: code, Message: message}
+}
+
+// Error is a problem raised by a handler. Err is the underlying cause, logged
+// but never shown to the client.
+type Error struct {
+	Type   Type
+	Detail string
+	Errors []FieldError
+	Err    error
+}
+
+func New(t Type, detail string) *Error {
+	return &Error{Type: t, Detail: detail}
+}
+
+func Newf(t Type, format string, args ...interface{}) *Error {
+	return New(t, fmt.Sprintf(format, args...))
+}
+
+// Wrap returns an internal error caused by err.
+func Wrap(err error) *Error {
+	return &Error{Type: Internal, Detail: "the request could not be completed", Err: err}
+}
+
+// Bind reports a request body echo could not bind.
+func Bind(err error) *Error {
+	detail := "bad request body"
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		if message, ok := httpErr.Message.(string); ok {
+			detail += ": " + message
+		}
+	}
+	return &Error{Type: BadRequest, Detail: detail, Err: err}
+}
+
+func (e *Error) WithErrors(errs []FieldError) *Error {
+	e.Errors = errs
+	return e
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Type.Title, e.Detail, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Type.Title, e.Detail)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"requestId,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// HTTPErrorHandler is an echo.HTTPErrorHandler writing every error as
+// application/problem+json. Errors that are neither an *Error nor an
+// *echo.HTTPError are reported as internal errors.
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+	p := FromError(err, c)
+	if p.Status >= http.StatusInternalServerError {
+		c.Logger().Error(err)
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(p.Status)
+	} else {
+		c.Response().Header().Set(echo.HeaderContentType, MIMEApplicationProblemJSON)
+		c.Response().WriteHeader(p.Status)
+		err = c.Echo().JSONSerializer.Serialize(c, p, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+	}
+}
+
+// FromError describes err as a problem of the request of c. It lets a handler
+// report an error after its response has been committed, e.g. in a stream.
+func FromError(err error, c echo.Context) Problem {
+	p := newProblem(err)
+	p.Instance = c.Request().URL.Path
+	p.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
+	if p.RequestID == "" {
+		p.RequestID = c.Request().Header.Get(echo.HeaderXRequestID)
+	}
+	return p
+}
+
+func newProblem(err error) Problem {
+	var problemErr *Error
+	if errors.As(err, &problemErr) {
+		return Problem{
+			Type:   problemErr.Type.URI,
+			Title:  problemErr.Type.Title,
+			Status: problemErr.Type.Status,
+			Detail: problemErr.Detail,
+			Errors: problemErr.Errors,
+		}
+	}
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		p := Problem{Type: "about:blank", Title: http.StatusText(httpErr.Code), Status: httpErr.Code}
+		if message, ok := httpErr.Message.(string); ok && message != http.StatusText(httpErr.Code) {
+			p.Detail = message
+		}
+		return p
+	}
+	return Problem{Type: Internal.URI, Title: Internal.Title, Status: Internal.Status}
+}
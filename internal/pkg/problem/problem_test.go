@@ -0,0 +1,48 @@
+package problem
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPErrorHandler(t *testing.T) {
+	testCases := []struct {
+		err          error
+		expectedCode int
+		expectedBody string
+	}{
+		{New(BadRequest, "taxYear must be a number"), http.StatusBadRequest, `{"type":"/problems/bad-request","title":"Bad request","status":400,"detail":"taxYear must be a number","instance":"/tax/calculations","requestId":"abc"}`},
+		{New(ValidationFailed, "the request has invalid fields").WithErrors([]FieldError{NewFieldError("wht", "negative", "wht must not be negative")}), http.StatusUnprocessableEntity, `{"type":"/problems/validation-failed","title":"Validation failed","status":422,"detail":"the request has invalid fields","instance":"/tax/calculations","requestId":"abc","errors":[{"field":"wht","code":"negative","message":"wht must not be negative"}]}`},
+		{New(NewType("unsupported-tax-year", "Unsupported tax year", http.StatusBadRequest), "unsupported tax year 2500"), http.StatusBadRequest, `{"type":"/problems/unsupported-tax-year","title":"Unsupported tax year","status":400,"detail":"unsupported tax year 2500","instance":"/tax/calculations","requestId":"abc"}`},
+		{echo.ErrUnauthorized, http.StatusUnauthorized, `{"type":"about:blank","title":"Unauthorized","status":401,"instance":"/tax/calculations","requestId":"abc"}`},
+		{echo.NewHTTPError(http.StatusBadRequest, "missing field"), http.StatusBadRequest, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"missing field","instance":"/tax/calculations","requestId":"abc"}`},
+		{Wrap(errors.New("connection refused")), http.StatusInternalServerError, `{"type":"/problems/internal","title":"Internal server error","status":500,"detail":"the request could not be completed","instance":"/tax/calculations","requestId":"abc"}`},
+		{errors.New("connection refused"), http.StatusInternalServerError, `{"type":"/problems/internal","title":"Internal server error","status":500,"instance":"/tax/calculations","requestId":"abc"}`},
+	}
+
+	for _, tc := range testCases {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.Response().Header().Set(echo.HeaderXRequestID, "abc")
+
+		HTTPErrorHandler(tc.err, c)
+
+		assert.Equal(t, tc.expectedCode, rec.Code)
+		assert.Equal(t, MIMEApplicationProblemJSON, rec.Header().Get(echo.HeaderContentType))
+		assert.JSONEq(t, tc.expectedBody, rec.Body.String())
+	}
+}
+
+func TestBind(t *testing.T) {
+	err := Bind(echo.NewHTTPError(http.StatusBadRequest, "Syntax error: offset=1, error=invalid character"))
+
+	assert.Equal(t, BadRequest, err.Type)
+	assert.Equal(t, "bad request body: Syntax error: offset=1, error=invalid character", err.Detail)
+}
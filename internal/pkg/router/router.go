@@ -4,27 +4,44 @@ import (
 	"database/sql"
 	"net/http"
 
+	"github.com/kidkrub/assessment-tax/internal/pkg/config"
 	"github.com/kidkrub/assessment-tax/internal/pkg/handler/admin"
 	"github.com/kidkrub/assessment-tax/internal/pkg/handler/tax"
 	cmw "github.com/kidkrub/assessment-tax/internal/pkg/middleware"
+	"github.com/kidkrub/assessment-tax/internal/pkg/problem"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
 
-func InitRoutes(db *sql.DB) *echo.Echo {
+func InitRoutes(db *sql.DB, taxConfig config.Tax, uploadConfig config.Upload) *echo.Echo {
 	e := echo.New()
+	e.HTTPErrorHandler = problem.HTTPErrorHandler
+	e.Use(middleware.RequestID())
 	e.GET("/", func(c echo.Context) error {
 		return c.String(http.StatusOK, "Hello, Go Bootcamp!")
 	})
-	th := tax.New(db)
+	th := tax.New(db, taxConfig, uploadConfig)
 	ah := admin.New(db)
 
 	e.POST("/tax/calculations", th.TaxCalculateHandler)
+	e.POST("/tax/calculations/inverse", th.TaxInverseCalculateHandler)
+	e.POST("/tax/calculations/optimize", th.TaxOptimizeHandler)
+	e.POST("/tax/calculations/payroll", th.TaxPayrollHandler)
+	e.POST("/tax/calculations/joint", th.TaxJointCalculateHandler)
+	e.POST("/tax/calculations/batch", th.TaxBulkCalculateHandler)
 	e.POST("/tax/calculations/upload-csv", th.TaxUploadCalulateHandler)
+	e.POST("/tax/batches", th.TaxBatchCreateHandler)
+	e.GET("/tax/batches/:id", th.TaxBatchGetHandler)
+	e.GET("/tax/batches/:id/results", th.TaxBatchResultsHandler)
+	e.DELETE("/tax/batches/:id", th.TaxBatchCancelHandler)
 
 	ag := e.Group("/admin")
 	ag.Use(middleware.BasicAuth(cmw.BasicAuthenticate()))
 	ag.POST("/deductions/:type", ah.SetDeductionValueHandler)
+	ag.GET("/brackets", ah.ListTaxBracketsHandler)
+	ag.GET("/brackets/:year", ah.GetTaxBracketsHandler)
+	ag.PUT("/brackets/:year", ah.SetTaxBracketsHandler)
+	ag.POST("/brackets/validate", ah.ValidateTaxBracketsHandler)
 
 	return e
 }
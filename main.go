@@ -11,6 +11,7 @@ import (
 
 	"github.com/kidkrub/assessment-tax/internal/pkg/config"
 	"github.com/kidkrub/assessment-tax/internal/pkg/db"
+	"github.com/kidkrub/assessment-tax/internal/pkg/handler/tax"
 	"github.com/kidkrub/assessment-tax/internal/pkg/router"
 )
 
@@ -18,6 +19,11 @@ func main() {
 	cfg := config.New()
 	serverConfig := cfg.Server()
 	dbConfig := cfg.Database()
+	taxConfig, err := cfg.Tax()
+	if err != nil {
+		log.Fatal(err)
+	}
+	uploadConfig := cfg.Upload()
 
 	db, err := db.InitDB(dbConfig.DatabaseUrl)
 	if err != nil {
@@ -26,7 +32,7 @@ func main() {
 
 	server := fmt.Sprintf("%s:%d", serverConfig.Hostname, serverConfig.PORT)
 
-	e := router.InitRoutes(db)
+	e := router.InitRoutes(db, taxConfig, uploadConfig)
 
 	ctx, shutdown := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer shutdown()
@@ -37,8 +43,17 @@ func main() {
 		}
 	}()
 
+	workerDone := make(chan struct{})
+	go func() {
+		defer close(workerDone)
+		if err := tax.NewBatchWorker(db, taxConfig, uploadConfig).Run(ctx); err != nil {
+			e.Logger.Error(err)
+		}
+	}()
+
 	<-ctx.Done()
 	fmt.Println("shutting down the server")
+	<-workerDone
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 